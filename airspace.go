@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/geo"
+)
+
+const (
+	// NauticalMile in meters, the radii of OpenAir files are given in nautical miles
+	NauticalMile float64 = 1852
+	// AirspaceWidth is the width of the outline in pixels of the image
+	AirspaceWidth float64 = 1
+)
+
+// Airspace is a single airspace of an OpenAir file
+type Airspace struct {
+	Class   string
+	Name    string
+	Floor   string
+	Ceiling string
+	Polygon orb.Ring
+}
+
+// AirspaceStyle is the outline and fill of an airspace class
+type AirspaceStyle struct {
+	Color color.NRGBA
+	// Fill is the alpha of the filled area, 0 draws only the outline
+	Fill   uint8
+	Dashed bool
+}
+
+// AirspaceStyles are the styles by class, other classes use DefaultAirspaceStyle
+var AirspaceStyles = map[string]AirspaceStyle{
+	"A":   {color.NRGBA{0xc6, 0x28, 0x28, 0xff}, 0x20, false},
+	"B":   {color.NRGBA{0xc6, 0x28, 0x28, 0xff}, 0x20, false},
+	"C":   {color.NRGBA{0x15, 0x65, 0xc0, 0xff}, 0x20, false},
+	"D":   {color.NRGBA{0x15, 0x65, 0xc0, 0xff}, 0x18, false},
+	"CTR": {color.NRGBA{0x6a, 0x1b, 0x9a, 0xff}, 0x28, false},
+	"E":   {color.NRGBA{0x00, 0x83, 0x8f, 0xff}, 0x00, true},
+	"R":   {color.NRGBA{0xd8, 0x43, 0x15, 0xff}, 0x30, false},
+	"P":   {color.NRGBA{0xb7, 0x1c, 0x1c, 0xff}, 0x40, false},
+	"Q":   {color.NRGBA{0xef, 0x6c, 0x00, 0xff}, 0x20, true},
+	"TMZ": {color.NRGBA{0x42, 0x42, 0x42, 0xff}, 0x00, true},
+	"RMZ": {color.NRGBA{0x42, 0x42, 0x42, 0xff}, 0x00, true},
+	"GP":  {color.NRGBA{0x2e, 0x7d, 0x32, 0xff}, 0x18, false},
+	"W":   {color.NRGBA{0x02, 0x88, 0xd1, 0xff}, 0x00, true},
+}
+
+// DefaultAirspaceStyle is used for classes without style, e.g. F or G
+var DefaultAirspaceStyle = AirspaceStyle{color.NRGBA{0x75, 0x75, 0x75, 0xff}, 0x00, true}
+
+// Style returns the style of the class of the airspace
+func (a *Airspace) Style() AirspaceStyle {
+	if style, ok := AirspaceStyles[a.Class]; ok {
+		return style
+	}
+	return DefaultAirspaceStyle
+}
+
+// LoadAirspaces parses the OpenAir file
+func LoadAirspaces(FileName string) ([]*Airspace, error) {
+	file, err := os.Open(FileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	airspaces, err := ParseOpenAir(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	return airspaces, nil
+}
+
+// ParseOpenAir reads the airspaces with polygons (DP), arcs (DA, DB) and circles (DC),
+// airways (DY) and the style records are ignored
+func ParseOpenAir(r io.Reader) ([]*Airspace, error) {
+	var (
+		airspaces []*Airspace
+		current   *Airspace
+		center    orb.Point
+		clockwise = true
+	)
+	finish := func() {
+		if current != nil && len(current.Polygon) > 2 {
+			if !current.Polygon.Closed() {
+				current.Polygon = append(current.Polygon, current.Polygon[0])
+			}
+			airspaces = append(airspaces, current)
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '*' {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		record, value := strings.ToUpper(fields[0]), ""
+		if len(fields) > 1 {
+			value = strings.TrimSpace(fields[1])
+		}
+		// names might contain a star, other records might be followed by a comment
+		if record != "AN" {
+			if i := strings.Index(value, "*"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+		if record == "AC" {
+			finish()
+			current = &Airspace{Class: strings.ToUpper(value)}
+			clockwise = true
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		var err error
+		switch record {
+		case "AN":
+			current.Name = value
+		case "AL":
+			current.Floor = value
+		case "AH":
+			current.Ceiling = value
+		case "V":
+			switch {
+			case strings.HasPrefix(strings.ToUpper(value), "X="):
+				center, err = ParseOpenAirCoordinate(value[2:])
+			case strings.HasPrefix(strings.ToUpper(value), "D="):
+				clockwise = strings.TrimSpace(value[2:]) != "-"
+			}
+		case "DP":
+			var point orb.Point
+			if point, err = ParseOpenAirCoordinate(value); err == nil {
+				current.Polygon = append(current.Polygon, point)
+			}
+		case "DC":
+			var radius float64
+			if radius, err = strconv.ParseFloat(value, 64); err == nil {
+				current.Polygon = append(current.Polygon, arc(center, radius*NauticalMile, 0, 360, true)...)
+			}
+		case "DA":
+			var values [3]float64
+			parts := strings.Split(value, ",")
+			if len(parts) != 3 {
+				err = fmt.Errorf("arc requires radius, start and end angle")
+				break
+			}
+			for i, part := range parts {
+				if values[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				current.Polygon = append(current.Polygon, arc(center, values[0]*NauticalMile, values[1], arcEnd(values[1], values[2], clockwise), false)...)
+			}
+		case "DB":
+			parts := strings.Split(value, ",")
+			if len(parts) != 2 {
+				err = fmt.Errorf("arc requires two coordinates")
+				break
+			}
+			var from, to orb.Point
+			if from, err = ParseOpenAirCoordinate(parts[0]); err != nil {
+				break
+			}
+			if to, err = ParseOpenAirCoordinate(parts[1]); err != nil {
+				break
+			}
+			start := geo.Bearing(center, from)
+			points := arc(center, geo.DistanceHaversine(center, from), start, arcEnd(start, geo.Bearing(center, to), clockwise), false)
+			// the arc ends exactly at the given coordinates
+			points[0], points[len(points)-1] = from, to
+			current.Polygon = append(current.Polygon, points...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+	return airspaces, nil
+}
+
+// arcEnd returns the end bearing so that the arc runs in the direction from start
+func arcEnd(start float64, end float64, clockwise bool) float64 {
+	if clockwise {
+		return start + math.Mod(math.Mod(end-start, 360)+360, 360)
+	}
+	return start - math.Mod(math.Mod(start-end, 360)+360, 360)
+}
+
+// ParseOpenAirCoordinate parses coordinates like 52:31:12 N 013:24:36 E or 52:31.2N 13:24.6E
+func ParseOpenAirCoordinate(value string) (orb.Point, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	i := strings.IndexAny(value, "NS")
+	j := strings.IndexAny(value, "EW")
+	if i < 0 || j < i {
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	lat, err := parseDegrees(value[:i])
+	if err != nil {
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	lon, err := parseDegrees(value[i+1 : j])
+	if err != nil {
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	if value[i] == 'S' {
+		lat = -lat
+	}
+	if value[j] == 'W' {
+		lon = -lon
+	}
+	return orb.Point{lon, lat}, nil
+}
+
+// parseDegrees parses degrees, minutes and seconds separated by colons
+func parseDegrees(value string) (degrees float64, err error) {
+	for i, part := range strings.Split(strings.TrimSpace(value), ":") {
+		if i > 2 {
+			return 0, fmt.Errorf("too many parts")
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, err
+		}
+		degrees += v / math.Pow(60, float64(i))
+	}
+	return degrees, nil
+}
+
+// DrawAirspaces draws the airspaces overlapping the grid, they are clipped to the
+// merged tiles with a margin so that their outline does not appear at the edges
+func DrawAirspaces(dc *gg.Context, grid *TileGrid, airspaces []*Airspace, width float64) {
+	bound := grid.Bound()
+	pad := (bound.Max[0] - bound.Min[0]) * 0.05
+	clipBound := orb.Bound{Min: orb.Point{bound.Min[0] - pad, bound.Min[1] - pad}, Max: orb.Point{bound.Max[0] + pad, bound.Max[1] + pad}}
+
+	dc.Push()
+	defer dc.Pop()
+	dc.SetLineJoin(gg.LineJoinRound)
+	dc.SetLineWidth(width)
+	for _, airspace := range airspaces {
+		// the grid might be unwrapped beyond the antimeridian
+		for _, shift := range []float64{0, -360, 360} {
+			polygon := airspace.Polygon
+			if shift != 0 {
+				polygon = make(orb.Ring, len(airspace.Polygon))
+				for i, point := range airspace.Polygon {
+					polygon[i] = orb.Point{point[0] + shift, point[1]}
+				}
+			}
+			if !polygon.Bound().Intersects(bound) {
+				continue
+			}
+			clipped := clip.Ring(clipBound, polygon)
+			if len(clipped) < 3 {
+				continue
+			}
+			style := airspace.Style()
+			dc.NewSubPath()
+			tracePoints(dc, grid, clipped)
+			dc.ClosePath()
+			if style.Fill > 0 {
+				fill := style.Color
+				fill.A = style.Fill
+				dc.SetColor(fill)
+				dc.FillPreserve()
+			}
+			if style.Dashed {
+				dc.SetDash(3*width, 2*width)
+			} else {
+				dc.SetDash()
+			}
+			dc.SetColor(style.Color)
+			dc.Stroke()
+		}
+	}
+}
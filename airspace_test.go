@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+const OpenAirExample = `* airspaces around Berlin
+AC CTR
+AN BERLIN CTR *tower
+AL GND
+AH 2500ft MSL
+DP 52:30:00 N 013:15:00 E
+DP 52:30:00 N 013:45:00 E
+DP 52:15:00 N 013:45:00 E
+DP 52:15:00 N 013:15:00 E
+
+AC R
+AN ED-R 146
+AL GND
+AH FL100
+V X=52:31:12 N 013:24:36 E
+DC 2
+
+AC D
+AN BRANDENBURG
+V X=52:24.0N 012:30.0E
+DP 52:24:00 N 012:20:00 E
+V D=-
+DA 5,270,90
+DB 52:24:00 N 012:40:00 E , 52:30:00 N 012:30:00 E *comment
+
+AC G
+AN INCOMPLETE
+DP 52:00:00 N 013:00:00 E
+`
+
+func TestParseOpenAir(t *testing.T) {
+	airspaces, err := ParseOpenAir(strings.NewReader(OpenAirExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(airspaces) != 3 {
+		t.Fatalf("Parsed %d airspaces, expected 3", len(airspaces))
+	}
+	ctr := airspaces[0]
+	if ctr.Class != "CTR" || ctr.Name != "BERLIN CTR *tower" || ctr.Ceiling != "2500ft MSL" || len(ctr.Polygon) != 5 || !ctr.Polygon.Closed() {
+		t.Errorf("CTR is %+v", ctr)
+	}
+	// the circle has a radius of 2 nm around the center
+	center := orb.Point{13.41, 52.52}
+	for _, point := range airspaces[1].Polygon {
+		if distance := geo.DistanceHaversine(center, point); math.Abs(distance-2*NauticalMile) > 1 {
+			t.Errorf("Point of circle is %v m from the center", distance)
+			break
+		}
+	}
+	// the counterclockwise arc from west to east runs through the south
+	arc := airspaces[2].Polygon[1 : 1+ZoneSegments/2+1]
+	for _, point := range arc {
+		if point[1] > 52.4+1e-9 {
+			t.Errorf("Arc runs through the north: %v", point)
+			break
+		}
+	}
+	if south := arc[len(arc)/2]; south[1] > 52.33 || math.Abs(south[0]-12.5) > 0.01 {
+		t.Errorf("Arc does not run through the south: %v", south)
+	}
+}
+
+func TestParseOpenAirInvalid(t *testing.T) {
+	for _, invalid := range []string{
+		"AC D\nDP 52:30:00 013:15:00 E\n",
+		"AC D\nDC two\n",
+		"AC D\nDA 5,270\n",
+	} {
+		if _, err := ParseOpenAir(strings.NewReader(invalid)); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%q should fail in line 2: %v", invalid, err)
+		}
+	}
+}
+
+func TestParseOpenAirCoordinate(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected orb.Point
+	}{
+		{"52:31:12 N 013:24:36 E", orb.Point{13.41, 52.52}},
+		{"52:31.2N 13:24.6E", orb.Point{13.41, 52.52}},
+		{"33:52:00 S 151:12:36 W", orb.Point{-151.21, -33.866667}},
+	}
+	for _, c := range cases {
+		point, err := ParseOpenAirCoordinate(c.value)
+		if err != nil || math.Abs(point[0]-c.expected[0]) > 1e-6 || math.Abs(point[1]-c.expected[1]) > 1e-6 {
+			t.Errorf("%s is %v, expected %v: %v", c.value, point, c.expected, err)
+		}
+	}
+}
+
+func TestDrawAirspaces(t *testing.T) {
+	// the airspace is larger than the grid and east of the antimeridian
+	grid := &TileGrid{Z: 2, X: 4, Y: 1, Columns: 1, Rows: 1, TileSize: 256, Section: image.Rect(0, 0, 256, 256)}
+	airspace := &Airspace{Class: "P", Polygon: orb.Ring{{-180, 0}, {-170, 0}, {-170, 80}, {-180, 80}, {-180, 0}}}
+	dc := gg.NewContext(256, 256)
+	DrawAirspaces(dc, grid, []*Airspace{airspace}, 1)
+	fill := AirspaceStyles["P"].Color
+	if current := color.NRGBAModel.Convert(dc.Image().At(10, 128)).(color.NRGBA); current.A != AirspaceStyles["P"].Fill || current.R != fill.R {
+		t.Errorf("Airspace is not filled: %v", current)
+	}
+	if _, _, _, a := dc.Image().At(100, 128).RGBA(); a != 0 {
+		t.Error("Airspace is drawn outside of its polygon")
+	}
+}
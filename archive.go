@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// TileReader is implemented by tile sources that read their tiles without download,
+// the tiles are not cached
+type TileReader interface {
+	ReadTile(z int16, x int16, y int16) ([]byte, error)
+}
+
+// tileReader returns the reader of the source, vector sources read the tiles of the source they wrap
+func tileReader(source TileSource) (TileReader, bool) {
+	switch s := source.(type) {
+	case TileReader:
+		return s, true
+	case *VectorSource:
+		return tileReader(s.TileSource)
+	}
+	return nil, false
+}
+
+// TileArchive is a local file containing the tiles of a source, e.g. MBTiles or PMTiles
+type TileArchive interface {
+	// ReadTile returns the tile in the XYZ numbering, an error is returned for missing tiles
+	ReadTile(z int16, x int16, y int16) ([]byte, error)
+	Info() ArchiveInfo
+}
+
+// ArchiveInfo is the metadata of an archive, empty values are unknown
+type ArchiveInfo struct {
+	// Format is the image format of the tiles or pbf for vector tiles
+	Format      string
+	MaxZoom     int16
+	Attribution string
+}
+
+// ArchiveSource reads the tiles from an archive instead of a tile server, the archive
+// is kept open for the lifetime of the source
+type ArchiveSource struct {
+	XYZSource
+	Archive TileArchive
+}
+
+func (s *ArchiveSource) ReadTile(z int16, x int16, y int16) ([]byte, error) {
+	return s.Archive.ReadTile(z, x, y)
+}
+
+// IsTileArchive returns whether the path is a MBTiles or PMTiles archive
+func IsTileArchive(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mbtiles", ".pmtiles":
+		return true
+	}
+	return false
+}
+
+// OpenTileArchive opens the archive by the extension of the path
+func OpenTileArchive(path string) (TileArchive, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mbtiles":
+		return OpenMBTiles(path)
+	case ".pmtiles":
+		return OpenPMTiles(path)
+	}
+	return nil, fmt.Errorf("unknown tile archive %s, available: .mbtiles, .pmtiles", path)
+}
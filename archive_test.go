@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// EncodeSolidTile returns a png tile of a single color
+func EncodeSolidTile(t *testing.T, size int, c color.Color) []byte {
+	im := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(im, im.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	CheckError(t, png.Encode(&buf, im))
+	return buf.Bytes()
+}
+
+// WritePMTiles creates the archive with gzipped directories, with leaf the root
+// directory points to a leaf directory containing the tiles
+func WritePMTiles(t *testing.T, path string, tileType uint8, tiles map[[3]int16][]byte, leaf bool) {
+	type tile struct {
+		id      uint64
+		content []byte
+	}
+	sorted := make([]tile, 0, len(tiles))
+	for key, content := range tiles {
+		sorted = append(sorted, tile{PMTileID(key[0], key[1], key[2]), content})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].id < sorted[j].id })
+	var data []byte
+	entries := make([]pmEntry, len(sorted))
+	for i, tile := range sorted {
+		entries[i] = pmEntry{TileID: tile.id, Offset: uint64(len(data)), Length: uint32(len(tile.content)), RunLength: 1}
+		data = append(data, tile.content...)
+	}
+	root, leaves := encodePMDirectory(t, entries), []byte{}
+	if leaf {
+		leaves = root
+		root = encodePMDirectory(t, []pmEntry{{TileID: entries[0].TileID, Offset: 0, Length: uint32(len(leaves))}})
+	}
+
+	header := make([]byte, PMTilesHeaderSize)
+	copy(header, "PMTiles")
+	header[7] = 3
+	offset := uint64(PMTilesHeaderSize)
+	for i, section := range [][]byte{root, nil, leaves, data} {
+		binary.LittleEndian.PutUint64(header[8+16*i:], offset)
+		binary.LittleEndian.PutUint64(header[16+16*i:], uint64(len(section)))
+		offset += uint64(len(section))
+	}
+	header[97], header[98], header[99], header[101] = pmCompressionGzip, pmCompressionNone, tileType, 18
+	content := append(append(append(header, root...), leaves...), data...)
+	CheckError(t, ioutil.WriteFile(path, content, 0644))
+}
+
+func encodePMDirectory(t *testing.T, entries []pmEntry) []byte {
+	var raw []byte
+	raw = appendUvarint(raw, uint64(len(entries)))
+	var last uint64
+	for _, e := range entries {
+		raw = appendUvarint(raw, e.TileID-last)
+		last = e.TileID
+	}
+	for _, e := range entries {
+		raw = appendUvarint(raw, uint64(e.RunLength))
+	}
+	for _, e := range entries {
+		raw = appendUvarint(raw, uint64(e.Length))
+	}
+	for i, e := range entries {
+		if i > 0 && e.Offset == entries[i-1].Offset+uint64(entries[i-1].Length) {
+			raw = appendUvarint(raw, 0)
+		} else {
+			raw = appendUvarint(raw, e.Offset+1)
+		}
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(raw)
+	CheckError(t, err)
+	CheckError(t, w.Close())
+	return buf.Bytes()
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(buf, tmp[:binary.PutUvarint(tmp, value)]...)
+}
+
+func TestPMTileID(t *testing.T) {
+	// ids of the specification
+	for tile, id := range map[[3]int16]uint64{
+		{0, 0, 0}:        0,
+		{1, 0, 0}:        1,
+		{1, 0, 1}:        2,
+		{1, 1, 1}:        3,
+		{1, 1, 0}:        4,
+		{2, 0, 0}:        5,
+		{12, 3423, 1763}: 19078479,
+	} {
+		if got := PMTileID(tile[0], tile[1], tile[2]); got != id {
+			t.Errorf("Tile %v has id %d instead of %d", tile, got, id)
+		}
+	}
+}
+
+// ArchiveTestTiles are a red and two blue tiles, the tile 2/0/0 is missing
+func ArchiveTestTiles(t *testing.T) (map[[3]int16][]byte, map[[3]int16]color.RGBA) {
+	red, blue := color.RGBA{0xff, 0, 0, 0xff}, color.RGBA{0, 0, 0xff, 0xff}
+	colors := map[[3]int16]color.RGBA{{2, 1, 0}: red, {2, 1, 3}: blue, {3, 5, 2}: blue}
+	tiles := make(map[[3]int16][]byte, len(colors))
+	for tile, c := range colors {
+		tiles[tile] = EncodeSolidTile(t, 256, c)
+	}
+	return tiles, colors
+}
+
+// CheckArchiveSource checks that the tiles of the archive are read with their colors
+// and that the archive is not copied into the cache
+func CheckArchiveSource(t *testing.T, path string, colors map[[3]int16]color.RGBA) {
+	t.Helper()
+	name := filepath.Base(path)
+	cache := NewTileCache(filepath.Join(filepath.Dir(path), "cache"), time.Hour, 0)
+	config := TileSourceConfig{Name: name, URL: path}
+	source, err := config.Source()
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if source.Format() != "png" {
+		t.Errorf("%s: format %s is not read from the archive", name, source.Format())
+	}
+	for tile, c := range colors {
+		im, tileErr := fetchTile(cache, source, tile[0], tile[1], tile[2])
+		if tileErr != nil {
+			t.Errorf("%s: %s", name, tileErr)
+			continue
+		}
+		if got := color.RGBAModel.Convert(im.At(10, 10)); got != c {
+			t.Errorf("%s: tile %v is %v instead of %v", name, tile, got, c)
+		}
+	}
+	if _, tileErr := fetchTile(cache, source, 2, 0, 0); tileErr == nil {
+		t.Errorf("%s: missing tile does not fail", name)
+	}
+	if _, err := os.Stat(cache.Dir); !os.IsNotExist(err) {
+		t.Errorf("%s: tiles of the archive are cached", name)
+	}
+}
+
+// CheckVectorArchive checks that the vector tile 5/8/5 of NewTestVectorTile is rasterized
+func CheckVectorArchive(t *testing.T, path string) {
+	t.Helper()
+	config := TileSourceConfig{Name: "vector", URL: path}
+	source, err := config.Source()
+	CheckError(t, err)
+	im, tileErr := fetchTile(NewTileCache("", time.Hour, 0), source, 5, 8, 5)
+	if tileErr != nil {
+		t.Fatal(tileErr)
+	}
+	if c := color.NRGBAModel.Convert(im.At(100, 256)); c != (color.NRGBA{0xaa, 0xd3, 0xdf, 0xff}) {
+		t.Errorf("Water of the vector tile is %v", c)
+	}
+}
+
+func TestTileArchives(t *testing.T) {
+	dir := t.TempDir()
+	tiles, colors := ArchiveTestTiles(t)
+	WritePMTiles(t, filepath.Join(dir, "test.pmtiles"), 2, tiles, false)
+	WritePMTiles(t, filepath.Join(dir, "leaf.pmtiles"), 2, tiles, true)
+	CheckArchiveSource(t, filepath.Join(dir, "test.pmtiles"), colors)
+	CheckArchiveSource(t, filepath.Join(dir, "leaf.pmtiles"), colors)
+
+	// vector tiles of archives are rasterized
+	WritePMTiles(t, filepath.Join(dir, "vector.pmtiles"), 1, map[[3]int16][]byte{{5, 8, 5}: NewTestVectorTile(t)}, false)
+	CheckVectorArchive(t, filepath.Join(dir, "vector.pmtiles"))
+
+	CheckError(t, ioutil.WriteFile(filepath.Join(dir, "invalid.pmtiles"), []byte("invalid"), 0644))
+	if _, err := OpenPMTiles(filepath.Join(dir, "invalid.pmtiles")); err == nil {
+		t.Errorf("Invalid archive is opened")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status of a flight in the journal of a batch
+const (
+	BatchRendered string = "rendered"
+	BatchFailed   string = "failed"
+)
+
+// Batch renders many flights with a bounded number of workers, which share the
+// tile cache of the options. Each result is appended to the journal, so that an
+// interrupted batch skips the rendered flights when it is started again.
+type Batch struct {
+	Source    FlightSource
+	Options   RenderOptions
+	Workers   int
+	OutputDir string
+	Prefix    string
+	// Journal is the json lines file with the results, empty disables resuming
+	Journal string
+}
+
+// BatchResult is the outcome of one flight, written as one line of the journal
+type BatchResult struct {
+	FlightID uint    `json:"id"`
+	Status   string  `json:"status"`
+	File     string  `json:"file,omitempty"`
+	Error    string  `json:"error,omitempty"`
+	Degraded int     `json:"degraded,omitempty"`
+	Seconds  float64 `json:"seconds"`
+}
+
+// BatchSummary counts the results of a batch
+type BatchSummary struct {
+	Rendered int
+	Failed   int
+	// Skipped flights were rendered by a previous run
+	Skipped int
+	// Pending flights were not rendered because the batch was cancelled
+	Pending int
+}
+
+// Run renders the flights until all are done or the context is cancelled, the
+// error reports failed flights or the cancellation
+func (b *Batch) Run(ctx context.Context, FlightIDs []uint) (BatchSummary, error) {
+	var summary BatchSummary
+	done, err := ReadBatchJournal(b.Journal)
+	if err != nil {
+		return summary, err
+	}
+	todo := make([]uint, 0, len(FlightIDs))
+	seen := make(map[uint]bool, len(FlightIDs))
+	for _, id := range FlightIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if done[id] {
+			summary.Skipped++
+		} else {
+			todo = append(todo, id)
+		}
+	}
+	log.Printf("Rendering %d flights with %d workers, %d flights were rendered before\n", len(todo), b.Workers, summary.Skipped)
+	if err := os.MkdirAll(b.OutputDir, 0777); err != nil {
+		return summary, err
+	}
+	var journal io.Writer = ioutil.Discard
+	if b.Journal != "" {
+		file, err := os.OpenFile(b.Journal, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			return summary, err
+		}
+		defer file.Close()
+		journal = file
+	}
+
+	jobs := make(chan uint)
+	results := make(chan BatchResult)
+	var wg sync.WaitGroup
+	workers := b.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				results <- b.render(ctx, id)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, id := range todo {
+			select {
+			case jobs <- id:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	encoder := json.NewEncoder(journal)
+	var journalErr error
+	for result := range results {
+		if result.Status == BatchFailed && ctx.Err() != nil {
+			// flights cancelled while rendering are not recorded, they are rendered by the next run
+			continue
+		}
+		if result.Status == BatchRendered {
+			summary.Rendered++
+			log.Printf("[%d/%d] Flight ID %d rendered to %s\n", summary.Rendered+summary.Failed, len(todo), result.FlightID, result.File)
+		} else {
+			summary.Failed++
+			log.Printf("[%d/%d] Flight ID %d failed: %s\n", summary.Rendered+summary.Failed, len(todo), result.FlightID, result.Error)
+		}
+		if err := encoder.Encode(result); err != nil && journalErr == nil {
+			// the workers are drained anyway, so that they do not block
+			journalErr = err
+		}
+	}
+	summary.Pending = len(todo) - summary.Rendered - summary.Failed
+	log.Printf("Rendered %d, failed %d, skipped %d, pending %d flights\n", summary.Rendered, summary.Failed, summary.Skipped, summary.Pending)
+	switch {
+	case journalErr != nil:
+		return summary, fmt.Errorf("writing the journal failed: %w", journalErr)
+	case ctx.Err() != nil:
+		return summary, fmt.Errorf("batch interrupted, %d flights are pending: %w", summary.Pending, ctx.Err())
+	case summary.Failed > 0:
+		return summary, fmt.Errorf("%d of %d flights failed", summary.Failed, len(todo))
+	}
+	return summary, nil
+}
+
+// render renders and saves one flight
+func (b *Batch) render(ctx context.Context, FlightID uint) BatchResult {
+	start := time.Now()
+	result := BatchResult{FlightID: FlightID, Status: BatchFailed}
+	rendering, err := RenderFlight(ctx, b.Source, FlightID, b.Options)
+	if err == nil {
+		result.File = filepath.Join(b.OutputDir, fmt.Sprintf("%sFlight_%d.png", b.Prefix, FlightID))
+		err = SaveImage(rendering, result.File)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.File = ""
+	} else {
+		result.Status = BatchRendered
+		result.Degraded = len(rendering.Degraded)
+	}
+	result.Seconds = time.Since(start).Seconds()
+	return result
+}
+
+// ReadBatchJournal returns the flights that were rendered according to the journal,
+// a missing journal has no flights
+func ReadBatchJournal(FileName string) (map[uint]bool, error) {
+	done := make(map[uint]bool)
+	if FileName == "" {
+		return done, nil
+	}
+	file, err := os.Open(FileName)
+	if os.IsNotExist(err) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var result BatchResult
+		// the last line is incomplete if the batch was killed while writing it
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			continue
+		}
+		// failed flights are retried
+		done[result.FlightID] = result.Status == BatchRendered
+	}
+	return done, scanner.Err()
+}
+
+// ReadFlightIDs reads flight ids separated by commas or white space, lines starting with # are ignored
+func ReadFlightIDs(r io.Reader) ([]uint, error) {
+	var ids []uint
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			id, err := strconv.ParseUint(field, 10, 32)
+			if err != nil || id == 0 {
+				return nil, fmt.Errorf("invalid flight id %q in line %d", field, line)
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids, scanner.Err()
+}
+
+// LoadFlightIDs reads the flight ids from the file, - reads from stdin
+func LoadFlightIDs(FileName string) ([]uint, error) {
+	if FileName == "-" {
+		return ReadFlightIDs(os.Stdin)
+	}
+	file, err := os.Open(FileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFlightIDs(file)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// CountingSource counts the flights fetched from the source
+type CountingSource struct {
+	FlightSource
+	mu    sync.Mutex
+	Calls map[uint]int
+}
+
+func (s *CountingSource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	s.mu.Lock()
+	s.Calls[FlightID]++
+	s.mu.Unlock()
+	return s.FlightSource.Flight(ctx, FlightID)
+}
+
+func TestBatch(t *testing.T) {
+	server := NewSolidTileServer(t, color.White)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "casper")
+	CheckError(t, err)
+	defer os.RemoveAll(dir)
+
+	source := &CountingSource{FlightSource: NewTestSource(), Calls: make(map[uint]int)}
+	batch := &Batch{Source: source, Options: NewTestOptions(server), Workers: 2, OutputDir: dir, Journal: filepath.Join(dir, "batch.jsonl")}
+	// flight 3 is empty and flight 4 does not exist
+	summary, err := batch.Run(context.Background(), []uint{1, 2, 3, 4, 1})
+	if err == nil {
+		t.Errorf("Failed flights are not reported")
+	}
+	if summary != (BatchSummary{Rendered: 2, Failed: 2}) {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	for _, id := range []string{"1", "2"} {
+		if _, err := os.Stat(filepath.Join(dir, "Flight_"+id+".png")); err != nil {
+			t.Errorf("Image of flight %s is missing", id)
+		}
+	}
+	done, err := ReadBatchJournal(batch.Journal)
+	CheckError(t, err)
+	if !reflect.DeepEqual(done, map[uint]bool{1: true, 2: true, 3: false, 4: false}) {
+		t.Errorf("Unexpected journal %v", done)
+	}
+
+	// the rendered flights are skipped when the batch is resumed, failed flights are retried
+	summary, _ = batch.Run(context.Background(), []uint{1, 2, 3, 4})
+	if summary != (BatchSummary{Skipped: 2, Failed: 2}) {
+		t.Errorf("Unexpected summary of the resumed batch %+v", summary)
+	}
+	if source.Calls[1] != 1 || source.Calls[3] != 2 {
+		t.Errorf("Unexpected flights fetched %v", source.Calls)
+	}
+
+	// a cancelled batch leaves the flights pending
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err = batch.Run(ctx, []uint{5, 6})
+	if !errors.Is(err, context.Canceled) || summary.Pending != 2 {
+		t.Errorf("Cancelled batch returns %+v, %v", summary, err)
+	}
+}
+
+func TestReadFlightIDs(t *testing.T) {
+	ids, err := ReadFlightIDs(strings.NewReader("# flights of the day\n1,2\n 3 4\n\n5\n"))
+	CheckError(t, err)
+	if !reflect.DeepEqual(ids, []uint{1, 2, 3, 4, 5}) {
+		t.Errorf("Unexpected flight ids %v", ids)
+	}
+	if _, err := ReadFlightIDs(strings.NewReader("1\nx\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Invalid flight id is not reported with its line: %v", err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// MetaSuffix is appended to the file name of a tile for its cache metadata
+	MetaSuffix      string        = ".meta"
+	DefaultCacheDir string        = "images/tmp"
+	DefaultCacheTTL time.Duration = 7 * 24 * time.Hour
+	// DefaultCacheSize is the size cap of the cache in bytes
+	DefaultCacheSize int64 = 1 << 30
+)
+
+// TileCache stores downloaded tiles on disk in Dir/source/z/x/y.format.
+// Tiles older than TTL are revalidated with the tile server and the least
+// recently used tiles are removed if the cache grows beyond MaxSize bytes.
+// An empty Dir disables the cache, tiles are then always downloaded.
+type TileCache struct {
+	// size is the running total of the cache in bytes, first for the 64 bit
+	// alignment of atomic operations
+	size int64
+	// scanned is set once the size was determined by walking the cache
+	scanned bool
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64
+	Client  *http.Client
+	// evicting is serialized, concurrent renders would otherwise remove the same files
+	mu sync.Mutex
+}
+
+// cacheMeta is stored next to each tile to revalidate it after the TTL expired
+type cacheMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+}
+
+// NewTileCache is a custom constructor for the tile cache
+func NewTileCache(Dir string, TTL time.Duration, MaxSize int64) *TileCache {
+	return &TileCache{
+		Dir:     Dir,
+		TTL:     TTL,
+		MaxSize: MaxSize,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Path returns the location of the tile in the cache
+func (c *TileCache) Path(source TileSource, z int16, x int16, y int16) string {
+	return filepath.Join(c.Dir, source.Name(), fmt.Sprint(z), fmt.Sprint(x), fmt.Sprintf("%d.%s", y, source.Format()))
+}
+
+// Get returns the content of the tile, the tile is downloaded if it is missing
+// in the cache and revalidated if it is expired
+func (c *TileCache) Get(source TileSource, z int16, x int16, y int16) ([]byte, error) {
+	if reader, ok := tileReader(source); ok {
+		return reader.ReadTile(z, x, y)
+	}
+	url := source.URL(z, x, y)
+	if path, ok := localPath(url); ok {
+		// local tiles are not cached
+		return ioutil.ReadFile(path)
+	}
+	if c.Dir == "" {
+		content, _, err := download(c.Client, url, cacheMeta{})
+		return content, err
+	}
+
+	path := c.Path(source, z, x, y)
+	meta, err := readCacheMeta(path)
+	cached := err == nil
+	if cached && time.Since(meta.Fetched) < c.TTL {
+		return readTile(path)
+	}
+
+	content, header, err := download(c.Client, url, meta)
+	if err != nil {
+		if cached {
+			// a stale tile is better than no tile
+			log.Printf("Revalidating %s failed, using cached tile: %s\n", url, err)
+			return readTile(path)
+		}
+		return nil, err
+	}
+	if content == nil {
+		// not modified since the last download
+		content, err = ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if err := c.put(path, content); err != nil {
+			return nil, err
+		}
+		meta.ETag = header.Get("ETag")
+		meta.LastModified = header.Get("Last-Modified")
+	}
+	meta.URL = url
+	meta.Fetched = time.Now()
+	if err := writeCacheMeta(path, meta); err != nil {
+		return nil, err
+	}
+	touch(path)
+	return content, nil
+}
+
+// put writes the tile and adds it to the running total of the cache, a replaced
+// tile is counted twice until the next eviction walks the cache
+func (c *TileCache) put(path string, content []byte) error {
+	if err := writeFileAtomic(path, bytes.NewReader(content)); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.size, int64(len(content)))
+	return nil
+}
+
+// localPath returns the path of tiles on the local file system, their url
+// either starts with file:// or has no scheme at all
+func localPath(url string) (string, bool) {
+	if strings.HasPrefix(url, "file://") {
+		return strings.TrimPrefix(url, "file://"), true
+	}
+	return url, !strings.Contains(url, "://")
+}
+
+// download requests the tile, a conditional request is sent if the meta data
+// of a cached tile is given and nil content is returned if it is not modified
+func download(client *http.Client, url string, meta cacheMeta) ([]byte, http.Header, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	// some tile servers like osm reject requests without user agent
+	req.Header.Set("User-Agent", UserAgent)
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && !meta.Fetched.IsZero():
+		return nil, resp.Header, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, fmt.Errorf("bad status: %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	return content, resp.Header, err
+}
+
+// Evict removes the least recently used tiles until the cache is smaller than MaxSize.
+// The cache is only walked on the first call and once the running total exceeds
+// MaxSize, the metadata of tiles downloaded since the last walk is not counted.
+func (c *TileCache) Evict() error {
+	if c.Dir == "" || c.MaxSize <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	before := atomic.LoadInt64(&c.size)
+	if c.scanned && before <= c.MaxSize {
+		return nil
+	}
+
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		entries []entry
+		total   int64
+	)
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files might be removed by other processes in the meantime
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, MetaSuffix) || strings.HasPrefix(info.Name(), ".download-") {
+			return nil
+		}
+		size := info.Size()
+		if meta, err := os.Stat(path + MetaSuffix); err == nil {
+			size += meta.Size()
+		}
+		entries = append(entries, entry{path, size, info.ModTime()})
+		total += size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.scanned = true
+	// tiles downloaded during the walk are kept in the total, even if the walk counted
+	// them already, which only leads to an earlier walk
+	defer func() { atomic.AddInt64(&c.size, total-before) }()
+	if total <= c.MaxSize {
+		return nil
+	}
+
+	// the access time is tracked with the modification time of the tile
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	removed := 0
+	for _, e := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		_ = os.Remove(e.path + MetaSuffix)
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.size
+		removed++
+	}
+	log.Printf("Evicted %d tiles from cache\n", removed)
+	return nil
+}
+
+func readCacheMeta(path string) (meta cacheMeta, err error) {
+	if _, err = os.Stat(path); err != nil {
+		return
+	}
+	content, err := ioutil.ReadFile(path + MetaSuffix)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(content, &meta)
+	return
+}
+
+func writeCacheMeta(path string, meta cacheMeta) error {
+	content, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+MetaSuffix, bytes.NewReader(content))
+}
+
+// writeFileAtomic writes to a temporary file first, so that concurrent readers never see partial tiles
+func writeFileAtomic(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".download-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readTile reads a cached tile and marks it as recently used
+func readTile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	touch(path)
+	return content, nil
+}
+
+// touch marks the tile as recently used
+func touch(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// NewTestTileServer serves the same content for every tile and counts the requests
+func NewTestTileServer(content []byte, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Header.Get("If-None-Match") == `"tile"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"tile"`)
+		w.Write(content)
+	}))
+}
+
+func TestTileCache(t *testing.T) {
+	requests := 0
+	server := NewTestTileServer([]byte("tile"), &requests)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := &XYZSource{ID: "test", Template: server.URL + "/{z}/{x}/{y}.png", Size: 256, Zoom: 18, ImageFormat: "png"}
+	cache := NewTileCache(dir, time.Hour, 0)
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(source, 4, 8, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Cached tile is downloaded again, requests: %d", requests)
+	}
+	// the same x and y on another zoom level is a different tile
+	if _, err := cache.Get(source, 5, 8, 5); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Tile of another zoom level is served from cache, requests: %d", requests)
+	}
+
+	// expired tiles are revalidated with the etag
+	cache.TTL = 0
+	content, err := cache.Get(source, 4, 8, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Errorf("Expired tile is not revalidated, requests: %d", requests)
+	}
+	if string(content) != "tile" {
+		t.Errorf("Revalidated tile has been modified: %s", content)
+	}
+
+	// without directory the cache is disabled
+	if _, err := NewTileCache("", time.Hour, 0).Get(source, 4, 8, 5); err != nil || requests != 4 {
+		t.Errorf("Disabled cache does not download the tile, requests: %d", requests)
+	}
+
+	// the least recently used tile is evicted
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.Path(source, 5, 8, 5), old, old)
+	cache.MaxSize = 200
+	if err := cache.Evict(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cache.Path(source, 5, 8, 5)); !os.IsNotExist(err) {
+		t.Errorf("Least recently used tile is not evicted")
+	}
+	if _, err := os.Stat(cache.Path(source, 4, 8, 5)); err != nil {
+		t.Errorf("Recently used tile is evicted")
+	}
+
+	// the cache is not walked again until the downloaded tiles exceed the size
+	foreign := cache.Path(source, 6, 8, 5)
+	CheckError(t, writeFileAtomic(foreign, strings.NewReader(strings.Repeat("x", 1000))))
+	os.Chtimes(foreign, old, old)
+	CheckError(t, cache.Evict())
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("Cache is walked below the size")
+	}
+	cache.TTL = time.Hour
+	for i := 0; i < 30; i++ {
+		_, err := cache.Get(source, 7, int16(i), 5)
+		CheckError(t, err)
+	}
+	CheckError(t, cache.Evict())
+	if _, err := os.Stat(foreign); !os.IsNotExist(err) {
+		t.Errorf("Cache is not walked beyond the size")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb/geo"
+)
+
+// Attributes the track can be colored by
+const (
+	ColorByAltitude string = "altitude"
+	ColorBySpeed    string = "speed"
+	ColorByVario    string = "vario"
+)
+
+const (
+	// VarioWindow is the minimal duration in seconds the climb rate is averaged over,
+	// the altitude of consecutive fixes is too noisy otherwise
+	VarioWindow float64 = 10.0
+	// ColorLevels quantizes the colors, segments of the same level are drawn as one path
+	ColorLevels int = 64
+)
+
+var ErrMissingAttribute = errors.New("track does not contain the attribute")
+
+// Palette is a gradient of evenly distributed colors
+type Palette []color.NRGBA
+
+// Palettes are the built-in gradients selectable by name
+var Palettes = map[string]Palette{
+	// perceptually uniform, based on matplotlib
+	"viridis": {{0x44, 0x01, 0x54, 0xff}, {0x3b, 0x52, 0x8b, 0xff}, {0x21, 0x90, 0x8d, 0xff}, {0x5d, 0xc9, 0x63, 0xff}, {0xfd, 0xe7, 0x25, 0xff}},
+	"rainbow": {{0x30, 0x12, 0x3b, 0xff}, {0x28, 0xbc, 0xeb, 0xff}, {0xa4, 0xfc, 0x3c, 0xff}, {0xfb, 0x80, 0x22, 0xff}, {0x7a, 0x04, 0x03, 0xff}},
+	// from the valley to the glacier
+	"terrain": {{0x1a, 0x96, 0x41, 0xff}, {0xa6, 0xd9, 0x6a, 0xff}, {0xfd, 0xae, 0x61, 0xff}, {0x8c, 0x51, 0x0a, 0xff}, {0xf5, 0xf5, 0xf5, 0xff}},
+	// diverging from sink (blue) to climb (red)
+	"vario": {{0x21, 0x66, 0xac, 0xff}, {0x92, 0xc5, 0xde, 0xff}, {0xf7, 0xf7, 0xf7, 0xff}, {0xf4, 0xa5, 0x82, 0xff}, {0xb2, 0x18, 0x2b, 0xff}},
+}
+
+// DefaultPalettes are used if no palette is selected
+var DefaultPalettes = map[string]string{
+	ColorByAltitude: "terrain",
+	ColorBySpeed:    "viridis",
+	ColorByVario:    "vario",
+}
+
+// ParsePalette returns the built-in palette or a custom palette of comma separated hex colors
+func ParsePalette(value string) (Palette, error) {
+	if palette, ok := Palettes[value]; ok {
+		return palette, nil
+	}
+	var palette Palette
+	for _, hex := range strings.Split(value, ",") {
+		c, err := ParseHexColor(strings.TrimSpace(hex))
+		if err != nil {
+			return nil, fmt.Errorf("unknown palette %q, available: %s or comma separated colors", value, strings.Join(PaletteNames(), ", "))
+		}
+		palette = append(palette, c.(color.NRGBA))
+	}
+	if len(palette) < 2 {
+		return nil, fmt.Errorf("palette %q requires at least two colors", value)
+	}
+	return palette, nil
+}
+
+// PaletteNames returns the sorted names of the built-in palettes
+func PaletteNames() []string {
+	names := make([]string, 0, len(Palettes))
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// At interpolates the color at t between 0 and 1
+func (p Palette) At(t float64) color.NRGBA {
+	t = math.Max(0, math.Min(1, t)) * float64(len(p)-1)
+	i := int(t)
+	if i >= len(p)-1 {
+		return p[len(p)-1]
+	}
+	f := t - float64(i)
+	mix := func(a uint8, b uint8) uint8 { return uint8(math.Round(float64(a) + f*(float64(b)-float64(a)))) }
+	return color.NRGBA{mix(p[i].R, p[i+1].R), mix(p[i].G, p[i+1].G), mix(p[i].B, p[i+1].B), mix(p[i].A, p[i+1].A)}
+}
+
+// ValidColorBy checks the attribute the track is colored by, empty disables the coloring
+func ValidColorBy(attribute string) bool {
+	switch attribute {
+	case "", ColorByAltitude, ColorBySpeed, ColorByVario:
+		return true
+	}
+	return false
+}
+
+// SegmentValues returns the value of the attribute for each segment between two fixes
+func SegmentValues(track *Track, attribute string) ([]float64, error) {
+	n := len(track.Line) - 1
+	if n < 1 {
+		return nil, nil
+	}
+	needsTime := attribute != ColorByAltitude
+	needsAlt := attribute != ColorBySpeed
+	if (needsTime && len(track.Time) != len(track.Line)) || (needsAlt && len(track.Alt) != len(track.Line)) {
+		return nil, fmt.Errorf("%w %s", ErrMissingAttribute, attribute)
+	}
+
+	values := make([]float64, n)
+	for i := 0; i < n; i++ {
+		switch attribute {
+		case ColorByAltitude:
+			values[i] = (track.Alt[i] + track.Alt[i+1]) / 2
+		case ColorBySpeed:
+			// km/h, fixes with the same time keep the speed of the previous segment
+			if dt := track.Time[i+1] - track.Time[i]; dt > 0 {
+				values[i] = geo.Distance(track.Line[i], track.Line[i+1]) / dt * 3.6
+			} else if i > 0 {
+				values[i] = values[i-1]
+			}
+		case ColorByVario:
+			// m/s, averaged until the window is reached
+			j := i + 1
+			for j < n && track.Time[j]-track.Time[i] < VarioWindow {
+				j++
+			}
+			if dt := track.Time[j] - track.Time[i]; dt > 0 {
+				values[i] = (track.Alt[j] - track.Alt[i]) / dt
+			}
+		}
+	}
+	return values, nil
+}
+
+// ValueRange returns the range of the values the palette is spread over, outliers
+// are ignored and the climb rate is centered around zero
+func ValueRange(values []float64, attribute string) (min float64, max float64) {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	min = sorted[len(sorted)*2/100]
+	max = sorted[len(sorted)-1-len(sorted)*2/100]
+	if attribute == ColorByVario {
+		max = math.Max(math.Abs(min), math.Abs(max))
+		min = -max
+	}
+	if max == min {
+		max = min + 1
+	}
+	return
+}
+
+// AttributeUnit returns the unit of the attribute for the legend
+func AttributeUnit(attribute string) string {
+	switch attribute {
+	case ColorByAltitude:
+		return "m"
+	case ColorBySpeed:
+		return "km/h"
+	case ColorByVario:
+		return "m/s"
+	}
+	return ""
+}
+
+// DrawColoredTrack strokes each segment of the line in the color of its value, the halo
+// is drawn beneath the whole line first. Consecutive segments of the same color are
+// drawn as one path so that long tracks do not need a stroke per fix.
+func DrawColoredTrack(dc *gg.Context, grid *TileGrid, track *Track, values []float64, palette Palette, min float64, max float64, style LineStyle, scale float64) {
+	dc.Push()
+	defer dc.Pop()
+	dc.SetLineJoin(style.Join)
+	dc.SetLineCap(style.Cap)
+	if style.HaloWidth > 0 {
+		tracePath(dc, grid, track.Line, (style.Width+2*style.HaloWidth)*scale)
+		dc.SetColor(style.HaloColor)
+		dc.Stroke()
+	}
+	// round caps hide the gaps between the paths of different colors
+	dc.SetLineCap(gg.LineCapRound)
+	level := func(value float64) int {
+		return int(math.Round((value - min) / (max - min) * float64(ColorLevels-1)))
+	}
+	for start := 0; start < len(values); {
+		end := start + 1
+		for end < len(values) && level(values[end]) == level(values[start]) {
+			end++
+		}
+		tracePath(dc, grid, track.Line[start:end+1], style.Width*scale)
+		dc.SetColor(palette.At(float64(clamp(level(values[start]), 0, ColorLevels-1)) / float64(ColorLevels-1)))
+		dc.Stroke()
+		start = end
+	}
+}
+
+// DrawLegend draws the gradient with the range of the attribute in the bottom left corner
+func DrawLegend(dc *gg.Context, palette Palette, min float64, max float64, attribute string) {
+	const (
+		margin  = 10.0
+		padding = 6.0
+		barW    = 120.0
+		barH    = 8.0
+	)
+	unit := AttributeUnit(attribute)
+	minLabel := fmt.Sprintf("%.0f %s", min, unit)
+	maxLabel := fmt.Sprintf("%.0f %s", max, unit)
+	if attribute == ColorByVario {
+		minLabel = fmt.Sprintf("%.1f %s", min, unit)
+		maxLabel = fmt.Sprintf("%+.1f %s", max, unit)
+	}
+	_, textH := dc.MeasureString(minLabel)
+	boxW := barW + 2*padding
+	boxH := barH + textH + 3*padding
+	x := margin
+	y := float64(dc.Height()) - margin - boxH
+
+	dc.Push()
+	defer dc.Pop()
+	dc.SetRGBA(1, 1, 1, 0.8)
+	dc.DrawRoundedRectangle(x, y, boxW, boxH, 4)
+	dc.Fill()
+	gradient := gg.NewLinearGradient(x+padding, 0, x+padding+barW, 0)
+	for i, c := range palette {
+		gradient.AddColorStop(float64(i)/float64(len(palette)-1), c)
+	}
+	dc.SetFillStyle(gradient)
+	dc.DrawRectangle(x+padding, y+padding, barW, barH)
+	dc.Fill()
+	dc.SetRGB(0.2, 0.2, 0.2)
+	textY := y + 2*padding + barH + textH/2
+	dc.DrawStringAnchored(minLabel, x+padding, textY, 0, 0.5)
+	dc.DrawStringAnchored(maxLabel, x+padding+barW, textY, 1, 0.5)
+}
+
+// DrawFlightLegend lists the labels of the flights with their color in the bottom left
+// corner, labels that do not fit on the image are summarized in the last line
+func DrawFlightLegend(dc *gg.Context, labels []string, colors []color.NRGBA) {
+	const (
+		margin  = 10.0
+		padding = 6.0
+		swatch  = 12.0
+		spacing = 4.0
+	)
+	_, textH := dc.MeasureString("Mg")
+	lineH := math.Max(textH, swatch) + spacing
+	fit := int((float64(dc.Height()) - 2*margin - 2*padding + spacing) / lineH)
+	if fit <= 0 {
+		return
+	}
+	lines := labels
+	summarized := len(labels) > fit
+	if summarized {
+		lines = append(append([]string(nil), labels[:fit-1]...), fmt.Sprintf("+%d more", len(labels)-fit+1))
+	}
+	textW := 0.0
+	for _, line := range lines {
+		w, _ := dc.MeasureString(line)
+		textW = math.Max(textW, w)
+	}
+	boxW := swatch + spacing + textW + 2*padding
+	boxH := float64(len(lines))*lineH - spacing + 2*padding
+	x := margin
+	y := float64(dc.Height()) - margin - boxH
+
+	dc.Push()
+	defer dc.Pop()
+	dc.SetRGBA(1, 1, 1, 0.8)
+	dc.DrawRoundedRectangle(x, y, boxW, boxH, 4)
+	dc.Fill()
+	for i, line := range lines {
+		center := y + padding + float64(i)*lineH + (lineH-spacing)/2
+		if !summarized || i < len(lines)-1 {
+			dc.SetColor(colors[i%len(colors)])
+			dc.DrawRectangle(x+padding, center-swatch/2, swatch, swatch)
+			dc.Fill()
+		}
+		dc.SetRGB(0.2, 0.2, 0.2)
+		dc.DrawStringAnchored(line, x+padding+swatch+spacing, center, 0, 0.5)
+	}
+}
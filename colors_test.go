@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+)
+
+func TestPaletteAt(t *testing.T) {
+	palette := Palette{{0, 0, 0, 0xff}, {200, 100, 0, 0xff}, {200, 200, 200, 0xff}}
+	cases := []struct {
+		t        float64
+		expected color.NRGBA
+	}{
+		{-1, palette[0]},
+		{0, palette[0]},
+		{0.25, color.NRGBA{100, 50, 0, 0xff}},
+		{0.5, palette[1]},
+		{1, palette[2]},
+		{2, palette[2]},
+	}
+	for _, c := range cases {
+		if current := palette.At(c.t); current != c.expected {
+			t.Errorf("At(%v) is %v, expected %v", c.t, current, c.expected)
+		}
+	}
+}
+
+func TestParsePalette(t *testing.T) {
+	if palette, err := ParsePalette("viridis"); err != nil || len(palette) != len(Palettes["viridis"]) {
+		t.Errorf("Built-in palette is not parsed: %v", err)
+	}
+	if palette, err := ParsePalette("#000000, #ffffff"); err != nil || palette[1] != (color.NRGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("Custom palette is not parsed: %v %v", palette, err)
+	}
+	for _, value := range []string{"unknown", "#000000"} {
+		if _, err := ParsePalette(value); err == nil {
+			t.Errorf("Palette %q should be invalid", value)
+		}
+	}
+}
+
+func TestSegmentValues(t *testing.T) {
+	// straight north with 100 m per 4 s and a climb of 1 m/s
+	track := &Track{}
+	for i := 0; i < 10; i++ {
+		track.Line = append(track.Line, orb.Point{13, 52 + float64(i)*100/111195})
+		track.Alt = append(track.Alt, 1000+float64(i)*4)
+		track.Time = append(track.Time, float64(i)*4)
+	}
+	cases := []struct {
+		attribute string
+		expected  float64
+	}{
+		{ColorByAltitude, 1002},
+		{ColorBySpeed, 90},
+		{ColorByVario, 1},
+	}
+	for _, c := range cases {
+		values, err := SegmentValues(track, c.attribute)
+		if err != nil {
+			t.Errorf("%s: %s", c.attribute, err)
+			continue
+		}
+		if len(values) != 9 || math.Abs(values[0]-c.expected) > 0.5 {
+			t.Errorf("%s: values are %v, expected %v", c.attribute, values, c.expected)
+		}
+	}
+
+	// without time only the altitude is available
+	track.Time = nil
+	if _, err := SegmentValues(track, ColorBySpeed); !errors.Is(err, ErrMissingAttribute) {
+		t.Errorf("Speed without time should fail: %v", err)
+	}
+}
+
+func TestValueRange(t *testing.T) {
+	values := make([]float64, 100)
+	for i := range values {
+		values[i] = float64(i) - 60
+	}
+	// outliers are ignored
+	values[0], values[99] = -1000, 1000
+	if min, max := ValueRange(values, ColorByAltitude); min != -58 || max != 37 {
+		t.Errorf("Range is %v to %v", min, max)
+	}
+	if min, max := ValueRange(values, ColorByVario); min != -58 || max != 58 {
+		t.Errorf("Vario range is %v to %v", min, max)
+	}
+}
+
+func TestDrawColoredTrack(t *testing.T) {
+	grid := &TileGrid{Z: 0, Columns: 1, Rows: 1, TileSize: 256, Section: image.Rect(0, 0, 256, 256)}
+	track := &Track{Line: orb.LineString{{-90, 0}, {0, 0}, {90, 0}}}
+	palette := Palette{{0xff, 0, 0, 0xff}, {0, 0, 0xff, 0xff}}
+	style := LineStyle{Width: 4, Join: gg.LineJoinRound, Cap: gg.LineCapButt}
+	dc := gg.NewContext(256, 256)
+	DrawColoredTrack(dc, grid, track, []float64{0, 10}, palette, 0, 10, style, 1)
+	DrawLegend(dc, palette, 0, 10, ColorByAltitude)
+
+	for _, c := range []struct {
+		name     string
+		x, y     int
+		expected color.NRGBA
+	}{
+		{"low", 96, 128, palette[0]},
+		{"high", 160, 128, palette[1]},
+	} {
+		if current := color.NRGBAModel.Convert(dc.Image().At(c.x, c.y)); current != c.expected {
+			t.Errorf("%s: color is %v, expected %v", c.name, current, c.expected)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// FitRect returns the section of the canvas that contains the pixel bbox (minX, minY, maxX, maxY)
+// centered with padding on each side and with the aspect ratio of width and height. The padding
+// is relative to the extent of the bbox, the section is never smaller than width x height pixels
+// so that small flights are not upscaled beyond the resolution of the tiles.
+func FitRect(minX float64, minY float64, maxX float64, maxY float64, width int, height int, padding float64) image.Rectangle {
+	w, h := fitExtent(maxX-minX, maxY-minY, width, height, padding)
+	if w < float64(width) {
+		w, h = float64(width), float64(height)
+	}
+	centerX, centerY := (minX+maxX)/2, (minY+maxY)/2
+	x0, y0 := int(math.Round(centerX-w/2)), int(math.Round(centerY-h/2))
+	return image.Rect(x0, y0, x0+int(math.Round(w)), y0+int(math.Round(h)))
+}
+
+// fitExtent adds the padding to the extent and extends it to the aspect ratio of width and height
+func fitExtent(w float64, h float64, width int, height int, padding float64) (float64, float64) {
+	w *= 1 + 2*padding
+	h *= 1 + 2*padding
+	aspect := float64(width) / float64(height)
+	if h == 0 || w/h < aspect {
+		return h * aspect, h
+	}
+	return w, w / aspect
+}
+
+// ShiftInside moves the rectangle into the bounds as far as possible, the centering is
+// given up in favour of showing map instead of an empty border
+func ShiftInside(r image.Rectangle, bounds image.Rectangle) image.Rectangle {
+	shift := image.Point{}
+	if r.Dx() <= bounds.Dx() {
+		if r.Min.X < bounds.Min.X {
+			shift.X = bounds.Min.X - r.Min.X
+		} else if r.Max.X > bounds.Max.X {
+			shift.X = bounds.Max.X - r.Max.X
+		}
+	}
+	if r.Dy() <= bounds.Dy() {
+		if r.Min.Y < bounds.Min.Y {
+			shift.Y = bounds.Min.Y - r.Min.Y
+		} else if r.Max.Y > bounds.Max.Y {
+			shift.Y = bounds.Max.Y - r.Max.Y
+		}
+	}
+	return r.Add(shift)
+}
+
+// CropImage cuts the section out of the image and resamples it to width x height pixels,
+// parts of the section outside of the image are filled with the FallbackColor
+func CropImage(src image.Image, section image.Rectangle, width int, height int) *image.RGBA {
+	cropped := image.NewRGBA(image.Rect(0, 0, section.Dx(), section.Dy()))
+	if !section.In(src.Bounds()) {
+		draw.Draw(cropped, cropped.Bounds(), &image.Uniform{FallbackColor}, image.Point{}, draw.Src)
+	}
+	draw.Draw(cropped, cropped.Bounds(), src, section.Min, draw.Over)
+	if section.Dx() == width && section.Dy() == height {
+		return cropped
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), cropped, cropped.Bounds(), draw.Src, nil)
+	return dst
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFitRect(t *testing.T) {
+	cases := []struct {
+		name     string
+		bbox     [4]float64
+		width    int
+		height   int
+		expected image.Rectangle
+	}{
+		// 1000 x 500 pixels with 10 % padding on each side is 1200 x 600, the height is extended to 1:1
+		{"square", [4]float64{1000, 1000, 2000, 1500}, 480, 480, image.Rect(900, 650, 2100, 1850)},
+		// the height of 1200 x 600 is extended to 1200:630
+		{"opengraph", [4]float64{1000, 1000, 2000, 1500}, 1200, 630, image.Rect(900, 935, 2100, 1565)},
+		// small flights are not upscaled
+		{"thumbnail", [4]float64{100, 100, 110, 120}, 320, 180, image.Rect(-55, 20, 265, 200)},
+	}
+	for _, c := range cases {
+		section := FitRect(c.bbox[0], c.bbox[1], c.bbox[2], c.bbox[3], c.width, c.height, 0.1)
+		if section != c.expected {
+			t.Errorf("%s: section is %v, expected %v", c.name, section, c.expected)
+		}
+	}
+}
+
+func TestCropImage(t *testing.T) {
+	canvas := image.Rect(0, 0, 2048, 2048)
+	section := ShiftInside(image.Rect(-55, 20, 265, 200), canvas)
+	if section != image.Rect(0, 20, 320, 200) {
+		t.Errorf("Section is not shifted into the canvas: %v", section)
+	}
+	// sections larger than the canvas stay centered
+	if section := ShiftInside(image.Rect(-100, 0, 2148, 100), canvas); section.Min.X != -100 {
+		t.Errorf("Section larger than the canvas is shifted: %v", section)
+	}
+	cropped := CropImage(image.NewRGBA(canvas), image.Rect(929, 650, 2071, 1850), 1200, 630)
+	if cropped.Bounds() != image.Rect(0, 0, 1200, 630) {
+		t.Errorf("Image is not resampled to 1200 x 630: %v", cropped.Bounds())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrFlightNotFound = errors.New("flight not found")
+	ErrEmptyGeometry  = errors.New("flight has no geometry")
+	ErrTileFetch      = errors.New("tile fetch failed")
+	ErrTileDecode     = errors.New("tile decode failed")
+	ErrEncode         = errors.New("encoding image failed")
+	ErrImageTooLarge  = errors.New("image too large")
+)
+
+// Exit codes of the cli, every other error exits with 1
+const (
+	ExitFlightNotFound = 3
+	ExitEmptyGeometry  = 4
+	ExitTileFetch      = 5
+	ExitTileDecode     = 6
+	ExitEncode         = 7
+)
+
+// TileError is returned if a tile could not be fetched or decoded,
+// Reason is either ErrTileFetch or ErrTileDecode
+type TileError struct {
+	Reason error
+	Z      int16
+	X      int16
+	Y      int16
+	Err    error
+}
+
+func (e *TileError) Error() string {
+	return fmt.Sprintf("%s for tile %d/%d/%d: %s", e.Reason, e.Z, e.X, e.Y, e.Err)
+}
+
+func (e *TileError) Unwrap() error { return e.Err }
+
+// Is allows to check the reason with errors.Is(err, ErrTileFetch)
+func (e *TileError) Is(target error) bool { return e.Reason == target }
+
+// ExitCode maps an error of the pipeline to the exit code of the cli
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrFlightNotFound):
+		return ExitFlightNotFound
+	case errors.Is(err, ErrEmptyGeometry):
+		return ExitEmptyGeometry
+	case errors.Is(err, ErrTileFetch):
+		return ExitTileFetch
+	case errors.Is(err, ErrTileDecode):
+		return ExitTileDecode
+	case errors.Is(err, ErrEncode):
+		return ExitEncode
+	}
+	return 1
+}
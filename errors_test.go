@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, 0},
+		{errors.New("connection refused"), 1},
+		{fmt.Errorf("%w: %d", ErrFlightNotFound, 1), ExitFlightNotFound},
+		{fmt.Errorf("%w: %d", ErrEmptyGeometry, 1), ExitEmptyGeometry},
+		{&TileError{ErrTileFetch, 4, 8, 5, errors.New("bad status: 404 Not Found")}, ExitTileFetch},
+		{fmt.Errorf("rendering: %w", &TileError{ErrTileDecode, 4, 8, 5, errors.New("unknown format")}), ExitTileDecode},
+		{fmt.Errorf("%w: %s", ErrEncode, "short write"), ExitEncode},
+	}
+	for _, c := range cases {
+		if code := ExitCode(c.err); code != c.code {
+			t.Errorf("Exit code of %v is %d, expected %d", c.err, code, c.code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// Fallbacks for tiles that could not be fetched
+const (
+	// FallbackNone fails the rendering
+	FallbackNone string = "none"
+	// FallbackFill replaces the tile with a solid fill
+	FallbackFill string = "fill"
+	// FallbackParent upscales the matching part of a tile from a lower zoom level
+	// and uses the solid fill if no parent tile is available either
+	FallbackParent string = "parent"
+	// MaxParentLevels limits how many zoom levels are searched for a parent tile
+	MaxParentLevels int16 = 3
+)
+
+// FallbackColor is the solid fill of missing tiles
+var FallbackColor = color.RGBA{0xe0, 0xe0, 0xe0, 0xff}
+
+// fallbackTile returns the placeholder for the failed tile, parents caches the
+// parent tiles as neighbouring tiles often share the same parent
+func fallbackTile(cache *TileCache, source TileSource, failed *TileError, Fallback string, parents map[[3]int16]image.Image) image.Image {
+	size := source.TileSize()
+	if Fallback == FallbackParent {
+		for levels := int16(1); levels <= MaxParentLevels && failed.Z-levels >= 0; levels++ {
+			z, x, y := failed.Z-levels, failed.X>>uint(levels), failed.Y>>uint(levels)
+			key := [3]int16{z, x, y}
+			parent, ok := parents[key]
+			if !ok {
+				// failing parents are cached as nil as well
+				if im, err := fetchTile(cache, source, z, x, y); err == nil {
+					parent = im
+				}
+				parents[key] = parent
+			}
+			if parent != nil {
+				return upscaleQuadrant(parent, failed.X, failed.Y, levels, size)
+			}
+		}
+	}
+	placeholder := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(placeholder, placeholder.Bounds(), &image.Uniform{FallbackColor}, image.Point{}, draw.Src)
+	return placeholder
+}
+
+// upscaleQuadrant cuts the part of x and y out of the parent tile that is the given
+// number of zoom levels lower and scales it to the tile size
+func upscaleQuadrant(parent image.Image, x int16, y int16, levels int16, size int) image.Image {
+	bounds := parent.Bounds()
+	n := 1 << uint(levels)
+	w, h := bounds.Dx()/n, bounds.Dy()/n
+	// position of the tile within the parent
+	column, row := int(x)%n, int(y)%n
+	part := image.Rect(bounds.Min.X+column*w, bounds.Min.Y+row*h, bounds.Min.X+(column+1)*w, bounds.Min.Y+(row+1)*h)
+	tile := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(tile, tile.Bounds(), parent, part, draw.Src, nil)
+	return tile
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// FlightColors distinguish the flights of a multi-flight image, they are reused
+// in the same order if there are more flights than colors
+var FlightColors = []color.NRGBA{
+	{0x1f, 0x77, 0xb4, 0xff},
+	{0xd6, 0x27, 0x28, 0xff},
+	{0x2c, 0xa0, 0x2c, 0xff},
+	{0xff, 0x7f, 0x0e, 0xff},
+	{0x94, 0x67, 0xbd, 0xff},
+	{0x8c, 0x56, 0x4b, 0xff},
+	{0xe3, 0x77, 0xc2, 0xff},
+	{0x17, 0xbe, 0xcf, 0xff},
+	{0xbc, 0xbd, 0x22, 0xff},
+	{0x7f, 0x7f, 0x7f, 0xff},
+}
+
+// MaxFlights limits the number of flights rendered into one image
+const MaxFlights int = 500
+
+// Flight is a track together with the data shown in the legend
+type Flight struct {
+	ID    uint
+	Pilot string
+	// Airport is the name of the takeoff airport
+	Airport string
+	Track   Track
+}
+
+// Label returns the pilot name or the flight id if the name is unknown
+func (f *Flight) Label() string {
+	if f.Pilot != "" {
+		return f.Pilot
+	}
+	return fmt.Sprintf("Flight %d", f.ID)
+}
+
+// AlignLine shifts an unwrapped line by multiples of 360° so that it starts next to
+// the given longitude, flights near the antimeridian then share the same bbox
+func AlignLine(line orb.LineString, lon float64) orb.LineString {
+	if len(line) == 0 {
+		return line
+	}
+	shift := math.Round((lon-line[0][0])/360) * 360
+	if shift == 0 {
+		return line
+	}
+	aligned := make(orb.LineString, len(line))
+	for i, point := range line {
+		aligned[i] = orb.Point{point[0] + shift, point[1]}
+	}
+	return aligned
+}
+
+// Bound returns the bbox of the flight like LineBound
+func (f *Flight) Bound() orb.Bound {
+	return UnwrapLine(f.Track.Line).Bound()
+}
+
+// FlightsBound returns the union bbox of all flights like LineBound, the flights
+// have to be aligned to each other
+func FlightsBound(flights []*Flight) [4]float64 {
+	bound := flights[0].Bound()
+	for _, flight := range flights[1:] {
+		bound = bound.Union(flight.Bound())
+	}
+	return [4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]}
+}
+
+// ParseFlightIDs parses comma separated flight ids like 1,2,3
+func ParseFlightIDs(value string) ([]uint, error) {
+	var ids []uint
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("invalid flight id %q", field)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
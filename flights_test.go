@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+)
+
+func TestParseFlightIDs(t *testing.T) {
+	ids, err := ParseFlightIDs("1, 2,3")
+	if err != nil || len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Errorf("IDs are %v: %v", ids, err)
+	}
+	for _, value := range []string{"", "1,,2", "0", "-1", "a"} {
+		if _, err := ParseFlightIDs(value); err == nil {
+			t.Errorf("IDs %q should be invalid", value)
+		}
+	}
+}
+
+func TestFlightsBoundAcrossAntimeridian(t *testing.T) {
+	// both flights start at the Chatham Islands, the second one east of the antimeridian
+	first := UnwrapLine(orb.LineString{{176.5, -43.9}, {179.5, -43.5}})
+	second := UnwrapLine(orb.LineString{{-176.5, -44.0}, {179.0, -43.0}})
+	flights := []*Flight{
+		{ID: 1, Track: Track{Line: first}},
+		{ID: 2, Track: Track{Line: AlignLine(second, first[0][0])}},
+	}
+	bbox := FlightsBound(flights)
+	expected := [4]float64{176.5, -44.0, 183.5, -43.0}
+	if bbox != expected {
+		t.Errorf("Bbox is %v, expected %v", bbox, expected)
+	}
+}
+
+func TestFlightLabel(t *testing.T) {
+	if label := (&Flight{ID: 7}).Label(); label != "Flight 7" {
+		t.Errorf("Label is %q", label)
+	}
+	if label := (&Flight{ID: 7, Pilot: "Otto Lilienthal"}).Label(); label != "Otto Lilienthal" {
+		t.Errorf("Label is %q", label)
+	}
+}
+
+func TestDrawFlightLegend(t *testing.T) {
+	// more labels than fit on the image are summarized, the image must not be covered
+	labels := make([]string, 50)
+	for i := range labels {
+		labels[i] = (&Flight{ID: uint(i + 1)}).Label()
+	}
+	dc := gg.NewContext(200, 120)
+	DrawFlightLegend(dc, labels, FlightColors)
+	if _, _, _, a := dc.Image().At(5, 5).RGBA(); a != 0 {
+		t.Error("Legend covers the top of the image")
+	}
+	if _, _, _, a := dc.Image().At(12, 100).RGBA(); a == 0 {
+		t.Error("Legend is not drawn")
+	}
+}
@@ -1,116 +1,297 @@
 package main
 
 import (
-	_ "image"
-	_ "image/png"
+	"bytes"
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	_ "log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
 )
 
+// HypsometricTiles is the tile source the reference images are created with, the
+// tests read their tiles from an archive with the same tile size and zoom levels
+var HypsometricTiles = TileSources["hypsometric"]
+
+// OfflineTiles writes the tiles of the zoom level into a PMTiles archive replacing the
+// hypsometric tile server, the color of the tiles fades from north to south and
+// alternates like a checkerboard, so that misplaced tiles change the image
+func OfflineTiles(t *testing.T, z int16, tiles map[int64][2]int16) TileSource {
+	t.Helper()
+	dir := t.TempDir()
+	archive := make(map[[3]int16][]byte, len(tiles))
+	n := float64(int(1) << uint(z))
+	for _, tile := range tiles {
+		shade := uint8(255 * float64(tile[1]) / n)
+		green := uint8(96 + 96*((int(tile[0])+int(tile[1]))%2))
+		archive[[3]int16{z, tile[0], tile[1]}] = EncodeSolidTile(t, HypsometricTiles.TileSize(), color.RGBA{shade, green, 255 - shade, 255})
+	}
+	path := filepath.Join(dir, "hypsometric.pmtiles")
+	WritePMTiles(t, path, 2, archive, false)
+	config := TileSourceConfig{Name: "hypsometric", URL: path, TileSize: HypsometricTiles.TileSize(), MaxZoom: HypsometricTiles.MaxZoom()}
+	source, err := config.Source()
+	CheckError(t, err)
+	return source
+}
+
+// TestCache does not store tiles, the tiles of the archives are never cached
+var TestCache = NewTileCache("", DefaultCacheTTL, 0)
+
+// UpdateImages overwrites the reference images with the rendered images, run
+// go test -run TestCase -update after changing the rendering. The references are
+// rendered from the tiles of OfflineTiles, so they only catch changes of the tile
+// planning, merging, drawing and cropping since they were last updated.
+var UpdateImages = flag.Bool("update", false, "overwrite the reference images of the test cases")
+
+// TestCase is a bbox of a flight, ZoomLevel is the zoom of the 512 px hypsometric
+// tiles planned for the default image size. The zoom levels of the first version were
+// never checked and referred to the 256 px tiles below the root tile.
 type TestCase struct {
 	bbox      [4]float64
 	ZoomLevel int16
 	Name      string
 }
 
-func TestCaseBerlinNewYork(t *testing.T) {
+// RootTile returns the tile of the highest zoom level up to 9 that contains both
+// corners of the bbox
+func RootTile(bbox [4]float64) (x int16, y int16, z int16) {
+	for z = 9; z > 0; z-- {
+		x, y = Deg2num(bbox[0], bbox[1], z)
+		if x1, y1 := Deg2num(bbox[2], bbox[3], z); x == x1 && y == y1 {
+			return
+		}
+	}
+	return 0, 0, 0
+}
 
+// RenderTestCase plans the map of the bbox, connects its corners and compares the
+// cropped image with the reference images/{name}_Ref.png
+func RenderTestCase(t *testing.T, Case TestCase, name string) {
+	t.Helper()
+	grid, err := PlanTiles(Case.bbox, HypsometricTiles, ImageSize, ImageSize, BufferforCropping, DefaultDPI)
+	CheckError(t, err)
+	if grid.Z != Case.ZoomLevel {
+		t.Errorf("%s: zoom level is %d, expected %d", Case.Name, grid.Z, Case.ZoomLevel)
+	}
+	tiles := grid.Tiles()
+	// the corners are checked with Deg2num, which does not depend on the planning
+	planned := make(map[[2]int16]bool, len(tiles))
+	for _, tile := range tiles {
+		planned[tile] = true
+	}
+	for _, corner := range [][2]float64{{Case.bbox[0], Case.bbox[1]}, {Case.bbox[2], Case.bbox[3]}} {
+		if x, y := Deg2num(corner[0], corner[1], grid.Z); !planned[[2]int16{x, y}] {
+			t.Errorf("%s: tile %d/%d/%d of the corner %v is missing", Case.Name, grid.Z, x, y, corner)
+		}
+	}
+	images, _, err := FetchTiles(TestCache, OfflineTiles(t, grid.Z, tiles), tiles, grid.Z, FallbackNone)
+	CheckError(t, err)
+
+	dc := gg.NewContextForImage(grid.MergeTiles(images))
+	x0, y0 := grid.Pixel(Case.bbox[0], Case.bbox[1])
+	x1, y1 := grid.Pixel(Case.bbox[2], Case.bbox[3])
+	dc.DrawCircle(x0, y0, 5.0)
+	dc.DrawCircle(x1, y1, 5.0)
+	dc.DrawLine(x0, y0, x1, y1)
+	dc.SetRGB(0, 0, 0)
+	dc.SetLineWidth(2)
+	dc.Stroke()
+	cropped := CropImage(dc.Image(), ShiftInside(grid.Section, dc.Image().Bounds()), ImageSize, ImageSize)
+
+	current := filepath.Join(t.TempDir(), name+".png")
+	CheckError(t, gg.SavePNG(current, cropped))
+	reference := filepath.Join("images", name+"_Ref.png")
+	if *UpdateImages {
+		CheckError(t, gg.SavePNG(reference, cropped))
+	}
+	CheckImages(t, current, reference)
+}
+
+func TestCaseBerlinNewYork(t *testing.T) {
 	// BBox consist out of coordinates from Berlin and New York
 	// Coordinates based on https://www.gps-coordinates.net/
-	// Setup of different test cases to find zoom level and to Plot
-	// Berlin - New York
-	CaseBNY := TestCase{[4]float64{-74.006015, 40.71272, 13.38886, 52.517037}, 2, "Berlin - New York"}
+	RenderTestCase(t, TestCase{[4]float64{-74.006015, 40.71272, 13.38886, 52.517037}, 2, "Berlin - New York"}, "BerlinNewYork")
+}
 
-	ImageBNY := NewImage(CaseBNY.bbox)
+func TestCaseBerlinRio(t *testing.T) {
+	RenderTestCase(t, TestCase{[4]float64{-43.209373, -22.911014, 13.38886, 52.517037}, 2, "Berlin - RIO"}, "BerlinRio")
+}
 
-	// Find Tiles including the zoom level
-	ImageBNY.FindRootTile()
-	if ImageBNY.RootTile.X != 0 {
-		t.Errorf("Roottile X is not equal to 0, Current Value :%d", ImageBNY.RootTile.X)
+// CheckAntimeridian checks that the line crossing the antimeridian results in a narrow bbox
+// and a grid of valid tiles whose section contains all points of the line
+func CheckAntimeridian(t *testing.T, Case TestCase, line orb.LineString) {
+	bbox := LineBound(line)
+	for i := range bbox {
+		if math.Abs(bbox[i]-Case.bbox[i]) > 1e-6 {
+			t.Fatalf("%s: bbox is %v, expected %v", Case.Name, bbox, Case.bbox)
+		}
 	}
-	if ImageBNY.RootTile.Y != 0 {
-		t.Errorf("Roottile Y is not equal to 0")
+	source := &XYZSource{ID: "test", Size: 256, Zoom: 18, ImageFormat: "png"}
+	grid, err := PlanTiles(bbox, source, 480, 480, 0.1, DefaultDPI)
+	CheckError(t, err)
+	if grid.Z != Case.ZoomLevel {
+		t.Errorf("%s: zoom level is %d, expected %d", Case.Name, grid.Z, Case.ZoomLevel)
 	}
-	if ImageBNY.RootTile.Z != 0 {
-		t.Errorf("Zoom Level Z is not equal to 0")
+	n := int16(1) << uint(grid.Z)
+	west, east := false, false
+	for _, tile := range grid.Tiles() {
+		if tile[0] < 0 || tile[0] >= n {
+			t.Errorf("%s: tile x %d is not wrapped around", Case.Name, tile[0])
+		}
+		west = west || tile[0] == n-1
+		east = east || tile[0] == 0
 	}
-	tiles, ZoomIncrease := TilesDownload(ImageBNY.RootTile.X, ImageBNY.RootTile.Y, ImageBNY.RootTile.Z)
+	if !west || !east {
+		t.Errorf("%s: tiles on both sides of the antimeridian are missing", Case.Name)
+	}
+	for _, point := range UnwrapLine(line) {
+		x, y := grid.Pixel(point[0], point[1])
+		if !image.Pt(int(x), int(y)).In(grid.Section) {
+			t.Errorf("%s: point %v is outside of the section %v", Case.Name, point, grid.Section)
+		}
+	}
+}
 
-	// Download Tiles with Zoom Level
-	DownloadTiles(tiles, ImageBNY.RootTile.Z+ZoomIncrease)
-	CreateImage(tiles, "BerlinNewYork")
-	ImageBNY.DrawImage(&CaseBNY.bbox, tiles, ImageBNY.RootTile.Z, "BerlinNewYork", ImageBNY.RootTile.X, ImageBNY.RootTile.Y)
+func TestCaseAucklandChatham(t *testing.T) {
+	// Auckland (174.7633° E) - Chatham Islands (176.5597° W) crossing the antimeridian eastwards
+	CaseACHI := TestCase{[4]float64{174.7633, -43.9535, 183.4403, -36.8485}, 6, "Auckland - Chatham Islands"}
+	CheckAntimeridian(t, CaseACHI, orb.LineString{{174.7633, -36.8485}, {179.9, -40.1}, {-179.9, -40.5}, {-176.5597, -43.9535}})
+	if x, _ := Deg2num(183.4403, -43.9535, 6); x != 0 {
+		t.Errorf("Deg2num does not wrap around the antimeridian, current value %d", x)
+	}
+}
 
-	// Check Image Berlin New York
-	CheckImages("BerlinNewYork_merged_painted")
+func TestCaseAnchorageAttu(t *testing.T) {
+	// Anchorage (149.9003° W) - Attu Island (173.1897° E) crossing the antimeridian westwards
+	CaseAATU := TestCase{[4]float64{-186.8103, 52.2, -149.9003, 61.2181}, 4, "Anchorage - Attu Island"}
+	CheckAntimeridian(t, CaseAATU, orb.LineString{{-149.9003, 61.2181}, {-165.4, 54.1}, {-179.9, 52.2}, {179.9, 52.2}, {173.1897, 52.9}})
 }
 
-func TestCaseBerlinRio(t *testing.T) {
-	// Berlin - Rio Case
-	CaseBRIO := TestCase{[4]float64{-43.209373, -22.911014, 13.38886, 52.517037}, 2, "Berlin - RIO"}
-	ImageBRIO := NewImage(CaseBRIO.bbox)
+func TestCaseBerlinHamburg(t *testing.T) {
+	RenderTestCase(t, TestCase{[4]float64{10.000654, 52.517037, 13.38886, 53.550341}, 7, "Berlin - Hamburg"}, "BerlinHAM")
+}
 
-	// Find Tiles including the zoom level
-	ImageBRIO.FindRootTile()
-	ImageBRIO.ComposeImage("BerlinRio")
-	tiles, _ := TilesDownload(ImageBRIO.RootTile.X, ImageBRIO.RootTile.Y, ImageBRIO.RootTile.Z)
-	CreateImage(tiles, "BerlinRio")
-	ImageBRIO.DrawImage(&CaseBRIO.bbox, tiles, ImageBRIO.RootTile.Z, "BerlinRio", ImageBRIO.RootTile.X, ImageBRIO.RootTile.Y)
+func TestCaseBerlinBarcelona(t *testing.T) {
+	RenderTestCase(t, TestCase{[4]float64{2.154007, 41.390205, 13.38886, 52.517037}, 5, "Berlin - Barcelona"}, "BerlinBBARC")
 }
 
-func TestCaseBerlinHamburg(t *testing.T) {
+func TestFetchTilesFallback(t *testing.T) {
+	// the parent tile 1/0/0 is red, the tile 2/1/1 of the bottom right quarter is missing
+	parent := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	draw.Draw(parent, parent.Bounds(), &image.Uniform{color.RGBA{0xff, 0, 0, 0xff}}, image.Point{}, draw.Src)
+	draw.Draw(parent, image.Rect(128, 128, 256, 256), &image.Uniform{color.RGBA{0, 0, 0xff, 0xff}}, image.Point{}, draw.Src)
+	var encoded bytes.Buffer
+	CheckError(t, png.Encode(&encoded, parent))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/2/1/1.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+	source := &XYZSource{ID: "test", Template: server.URL + "/{z}/{x}/{y}.png", Size: 256, Zoom: 18, ImageFormat: "png"}
+	cache := NewTileCache("", DefaultCacheTTL, 0)
+	tiles := map[int64][2]int16{0: {0, 0}, 1: {1, 1}}
 
-	CaseBHAM := TestCase{[4]float64{10.000654, 52.517037, 13.38886, 53.550341}, 7, "Berlin - Hamburg"}
-	ImageBHAM := NewImage(CaseBHAM.bbox)
-	ImageBHAM.FindRootTile()
-	if ImageBHAM.RootTile.X != 8 {
-		t.Errorf("Roottile X is not equal to 0, the current values is %d", ImageBHAM.RootTile.X)
+	if _, _, err := FetchTiles(cache, source, tiles, 2, FallbackNone); !errors.Is(err, ErrTileFetch) {
+		t.Errorf("Missing tile does not fail without fallback: %v", err)
 	}
-	if ImageBHAM.RootTile.Y != 5 {
-		t.Errorf("Roottile Y is not equal to 0, the current values is %d", ImageBHAM.RootTile.Y)
+	images, degraded, err := FetchTiles(cache, source, tiles, 2, FallbackFill)
+	CheckError(t, err)
+	if len(degraded) != 1 || degraded[0].X != 1 || degraded[0].Y != 1 {
+		t.Errorf("Missing tile is not reported as degraded: %v", degraded)
 	}
-	if ImageBHAM.RootTile.Z != 4 {
-		t.Errorf("Zoom Level Z is not equal to 0, the current values is %d", ImageBHAM.RootTile.Z)
+	if c := color.RGBAModel.Convert(images[1].At(10, 10)); c != FallbackColor {
+		t.Errorf("Missing tile is not filled, current color %v", c)
 	}
-	tiles, ZoomIncrease := TilesDownload(ImageBHAM.RootTile.X, ImageBHAM.RootTile.Y, ImageBHAM.RootTile.Z)
-
-	// Download Tiles with Zoom Level
-	DownloadTiles(tiles, ImageBHAM.RootTile.Z+ZoomIncrease)
-	CreateImage(tiles, "BerlinNewYork")
+	// the upscaled bottom right quarter of the parent tile is blue
+	images, _, err = FetchTiles(cache, source, tiles, 2, FallbackParent)
+	CheckError(t, err)
+	if images[1].Bounds().Dx() != 256 {
+		t.Errorf("Parent tile is not upscaled to the tile size")
+	}
+	if r, _, b, _ := images[1].At(128, 128).RGBA(); r != 0 || b != 0xffff {
+		t.Errorf("Missing tile is not replaced by the matching part of the parent tile")
+	}
+}
 
-	CreateImage(tiles, "BerlinHAM")
-	ImageBHAM.DrawImage(&CaseBHAM.bbox, tiles, ImageBHAM.RootTile.Z, "BerlinHAM", ImageBHAM.RootTile.X, ImageBHAM.RootTile.Y)
+func TestFindRootTile(t *testing.T) {
+	// the root tiles asserted by the first version of the test cases
+	for _, expected := range []struct {
+		Case    TestCase
+		X, Y, Z int16
+	}{
+		{TestCase{[4]float64{-74.006015, 40.71272, 13.38886, 52.517037}, 2, "Berlin - New York"}, 0, 0, 0},
+		{TestCase{[4]float64{10.000654, 52.517037, 13.38886, 53.550341}, 7, "Berlin - Hamburg"}, 8, 5, 4},
+		{TestCase{[4]float64{8.682127, 50.110922, 8.7667933, 50.8021728}, 9, "Flight around Frankfurt am Main"}, 33, 21, 6},
+	} {
+		if x, y, z := RootTile(expected.Case.bbox); x != expected.X || y != expected.Y || z != expected.Z {
+			t.Errorf("%s: root tile is %d/%d/%d, expected %d/%d/%d", expected.Case.Name, z, x, y, expected.Z, expected.X, expected.Y)
+		}
+	}
+}
 
-	CheckImages("BerlinHAM_merged_painted")
+func TestCaseFlightFFM(t *testing.T) {
+	// bbox = min Longitude , min Latitude , max Longitude , max Latitude
+	RenderTestCase(t, TestCase{[4]float64{8.682127, 50.110922, 8.7667933, 50.8021728}, 9, "Flight around Frankfurt am Main"}, "FlightFFM")
 }
 
-func TestCaseBerlinBarcelona(t *testing.T) {
-	CaseBBARC := TestCase{[4]float64{2.154007, 41.390205, 13.38886, 52.517037}, 4, "Berlin - Barcelona"}
-	ImageBBARC := NewImage(CaseBBARC.bbox)
-	// Find Tiles including the zoom level
-	ImageBBARC.FindRootTile()
-	tiles, ZoomIncrease := TilesDownload(ImageBBARC.RootTile.X, ImageBBARC.RootTile.Y, ImageBBARC.RootTile.Z)
-	// Download Tiles with Zoom Level
-	DownloadTiles(tiles, ImageBBARC.RootTile.Z+ZoomIncrease)
-	// ImageBBARC.ComposeImage("BerlinBBARC")
-	CreateImage(tiles, "BerlinBBARC")
-	ImageBBARC.DrawImage(&ImageBBARC.bbox, tiles, ImageBBARC.RootTile.Z, "BerlinBBARC", ImageBBARC.RootTile.X, ImageBBARC.RootTile.Y)
-	CheckImages("BerlinBBARC_merged_painted")
+func CheckError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
-func TestFindRootTile(t *testing.T) {
+func ReadImage(t *testing.T, FileName string) image.Image {
+	t.Helper()
+	im, err := gg.LoadImage(FileName)
+	CheckError(t, err)
+	return im
+}
 
-	// bbox = min Longitude , min Latitude , max Longitude , max Latitude
-	CaseFlightFFM := TestCase{[4]float64{8.682127, 50.110922, 8.7667933, 50.8021728}, 7, "Flight around Frankfurt am Main"}
-	ImageFlightFFM := NewImage(CaseFlightFFM.bbox)
-	// Find Tiles including the zoom level
-	ImageFlightFFM.FindRootTile()
-	ImageFlightFFM.ComposeImage("FlightFFM")
-	// CheckImages("FlightFFM_merged")
-	tiles, ZoomIncrease := TilesDownload(ImageFlightFFM.RootTile.X, ImageFlightFFM.RootTile.Y, ImageFlightFFM.RootTile.Z)
-	DownloadTiles(tiles, ImageFlightFFM.RootTile.Z+ZoomIncrease)
-	CreateImage(tiles, "FlightFFM")
-	ImageFlightFFM.DrawImage(&ImageFlightFFM.bbox, tiles, ImageFlightFFM.RootTile.Z, "FlightFFM", ImageFlightFFM.RootTile.X, ImageFlightFFM.RootTile.Y)
-	CheckImages("FlightFFM_merged_painted")
+// CheckImages compares the pixels of the images, a few pixels may differ slightly,
+// e.g. by the anti-aliasing of another version of the drawing library
+func CheckImages(t *testing.T, Current string, Reference string) {
+	t.Helper()
+	current, reference := ReadImage(t, Current), ReadImage(t, Reference)
+	if current.Bounds() != reference.Bounds() {
+		t.Errorf("Size of %s is %v instead of %v", Current, current.Bounds(), reference.Bounds())
+		return
+	}
+	differing := 0
+	bounds := current.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(current.At(x, y)).(color.NRGBA)
+			r := color.NRGBAModel.Convert(reference.At(x, y)).(color.NRGBA)
+			if !closeChannels(c, r, 16) {
+				differing++
+			}
+		}
+	}
+	if limit := bounds.Dx() * bounds.Dy() / 1000; differing > limit {
+		t.Errorf("%d pixels of %s differ from %s, at most %d are allowed", differing, Current, Reference, limit)
+	}
+}
 
+// closeChannels returns whether all channels of the colors differ by at most the tolerance
+func closeChannels(a color.NRGBA, b color.NRGBA, tolerance int) bool {
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		if d > tolerance || d < -tolerance {
+			return false
+		}
+	}
+	return true
 }
@@ -3,12 +3,14 @@ module casper
 go 1.15
 
 require (
+	github.com/aws/aws-lambda-go v1.22.0
+	github.com/aws/aws-sdk-go v1.37.0
 	github.com/fogleman/gg v1.3.1-0.20210131172831-af4cd580789b
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/lib/pq v1.10.0
-	github.com/mitchellh/cli v1.1.2 // indirect
-	github.com/oliamb/cutter v0.2.2
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/paulmach/orb v0.2.1
 	github.com/urfave/cli/v2 v2.3.0
-	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb // indirect
+	golang.org/x/image v0.0.0-20210220032944-ac19c3e999fb
+	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
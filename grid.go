@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/paulmach/orb"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// DefaultDPI is the resolution the tiles are designed for
+	DefaultDPI float64 = 96.0
+	// MaxDPI limits the number of tiles fetched for a single image
+	MaxDPI float64 = 4 * DefaultDPI
+	// MaxGridZoom is the highest zoom level whose tile numbers fit into int16
+	MaxGridZoom int16 = 15
+	// MaxPixels limits the memory of a single image, width x height scaled by the dpi
+	MaxPixels int = MaxImageSize * MaxImageSize
+)
+
+// TileGrid is the block of Columns x Rows tiles of zoom level Z that covers the
+// section of the image, X and Y are the numbers of the top left tile. X is not
+// wrapped around and might be negative or beyond the last tile of the zoom level.
+type TileGrid struct {
+	Z        int16
+	X        int16
+	Y        int16
+	Columns  int
+	Rows     int
+	TileSize int
+	// Section of the merged tiles that is cropped for the image
+	Section image.Rectangle
+}
+
+// RequiredPixels returns the size of the section of the merged tiles, a higher dpi
+// fetches more detailed tiles
+func RequiredPixels(width int, height int, dpi float64) (int, int) {
+	scale := dpi / DefaultDPI
+	if scale <= 0 {
+		scale = 1
+	}
+	return int(math.Ceil(float64(width) * scale)), int(math.Ceil(float64(height) * scale))
+}
+
+// CheckPixels fails with ErrImageTooLarge if the section exceeds MaxPixels
+func CheckPixels(width int, height int, dpi float64) error {
+	w, h := RequiredPixels(width, height, dpi)
+	if w*h > MaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels at %.0f dpi exceed %d pixels", ErrImageTooLarge, width, height, dpi, MaxPixels)
+	}
+	return nil
+}
+
+// PlanTiles determines the zoom level and the tiles required to render the bbox
+// (min lon, min lat, max lon, max lat) with width x height pixels at the given dpi
+func PlanTiles(bbox [4]float64, source TileSource, width int, height int, padding float64, dpi float64) (*TileGrid, error) {
+	if err := CheckPixels(width, height, dpi); err != nil {
+		return nil, err
+	}
+	grid := &TileGrid{TileSize: source.TileSize()}
+	requiredWidth, requiredHeight := RequiredPixels(width, height, dpi)
+	grid.Z = ChooseZoom(bbox, grid.TileSize, requiredWidth, requiredHeight, padding, source.MaxZoom())
+
+	// Section in the pixel coordinates of the whole world at the zoom level
+	minX, maxY := LatLontoXY(float64(grid.TileSize), bbox[1], bbox[0], float64(grid.Z))
+	maxX, minY := LatLontoXY(float64(grid.TileSize), bbox[3], bbox[2], float64(grid.Z))
+	section := FitRect(minX, minY, maxX, maxY, requiredWidth, requiredHeight, padding)
+
+	// the tiles covering the section, rows beyond the poles do not exist whereas
+	// columns beyond the antimeridian are wrapped around when fetching the tiles
+	n := 1 << uint(grid.Z)
+	x0, x1 := floorDiv(section.Min.X, grid.TileSize), floorDiv(section.Max.X-1, grid.TileSize)
+	y0, y1 := floorDiv(section.Min.Y, grid.TileSize), floorDiv(section.Max.Y-1, grid.TileSize)
+	y0, y1 = clamp(y0, 0, n-1), clamp(y1, 0, n-1)
+	grid.X, grid.Y = int16(x0), int16(y0)
+	grid.Columns, grid.Rows = x1-x0+1, y1-y0+1
+	grid.Section = section.Sub(image.Point{x0 * grid.TileSize, y0 * grid.TileSize})
+	return grid, nil
+}
+
+// ChooseZoom returns the lowest zoom level at which the padded bbox covers at least
+// width x height pixels, limited by the maximum zoom level of the tile source
+func ChooseZoom(bbox [4]float64, TileSize int, width int, height int, padding float64, MaxZoom int16) int16 {
+	if MaxZoom > MaxGridZoom {
+		MaxZoom = MaxGridZoom
+	}
+	minX, maxY := LatLontoXY(float64(TileSize), bbox[1], bbox[0], 0)
+	maxX, minY := LatLontoXY(float64(TileSize), bbox[3], bbox[2], 0)
+	w, _ := fitExtent(maxX-minX, maxY-minY, width, height, padding)
+	if w <= 0 {
+		return MaxZoom
+	}
+	z := int16(math.Ceil(math.Log2(float64(width) / w)))
+	if z < 0 {
+		return 0
+	}
+	if z > MaxZoom {
+		return MaxZoom
+	}
+	return z
+}
+
+// Tiles returns the x and y numbers of all tiles of the grid column by column
+func (g *TileGrid) Tiles() map[int64][2]int16 {
+	tiles := make(map[int64][2]int16, g.Columns*g.Rows)
+	for column := 0; column < g.Columns; column++ {
+		for row := 0; row < g.Rows; row++ {
+			tiles[int64(column*g.Rows+row)] = [2]int16{WrapTileX(int(g.X)+column, g.Z), g.Y + int16(row)}
+		}
+	}
+	return tiles
+}
+
+// Pixel converts the coordinates to the pixel coordinates of the merged tiles,
+// the longitude has to be unwrapped in the same way as the bbox of the grid
+func (g *TileGrid) Pixel(lon float64, lat float64) (x float64, y float64) {
+	x, y = LatLontoXY(float64(g.TileSize), lat, lon, float64(g.Z))
+	return x - float64(int(g.X)*g.TileSize), y - float64(int(g.Y)*g.TileSize)
+}
+
+// Bound returns the extent of the merged tiles in degrees, the longitudes are not wrapped
+func (g *TileGrid) Bound() orb.Bound {
+	maxLat, minLon := Num2deg(int(g.X), int(g.Y), int(g.Z))
+	minLat, maxLon := Num2deg(int(g.X)+g.Columns, int(g.Y)+g.Rows, int(g.Z))
+	return orb.Bound{Min: orb.Point{minLon, minLat}, Max: orb.Point{maxLon, maxLat}}
+}
+
+// MergeTiles draws the tiles of the grid into one image, the tiles are keyed as by Tiles
+func (g *TileGrid) MergeTiles(tiles map[int64]image.Image) *image.RGBA {
+	merged := image.NewRGBA(image.Rect(0, 0, g.Columns*g.TileSize, g.Rows*g.TileSize))
+	for k, im := range tiles {
+		column, row := int(k)/g.Rows, int(k)%g.Rows
+		target := image.Rect(column*g.TileSize, row*g.TileSize, (column+1)*g.TileSize, (row+1)*g.TileSize)
+		// tiles of another size than announced by the source are scaled
+		if im.Bounds().Dx() != g.TileSize || im.Bounds().Dy() != g.TileSize {
+			draw.ApproxBiLinear.Scale(merged, target, im, im.Bounds(), draw.Src, nil)
+			continue
+		}
+		draw.Draw(merged, target, im, im.Bounds().Min, draw.Src)
+	}
+	return merged
+}
+
+func floorDiv(a int, b int) int {
+	return int(math.Floor(float64(a) / float64(b)))
+}
+
+func clamp(value int, min int, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
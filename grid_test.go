@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestPlanTiles(t *testing.T) {
+	source := &XYZSource{ID: "test", Size: 256, Zoom: 18, ImageFormat: "png"}
+	cases := []struct {
+		name string
+		bbox [4]float64
+		dpi  float64
+		zoom int16
+	}{
+		{"Berlin - Hamburg", [4]float64{10.000654, 52.517037, 13.38886, 53.550341}, DefaultDPI, 8},
+		{"Berlin - Hamburg retina", [4]float64{10.000654, 52.517037, 13.38886, 53.550341}, 2 * DefaultDPI, 9},
+		{"Berlin - New York", [4]float64{-74.006015, 40.71272, 13.38886, 52.517037}, DefaultDPI, 3},
+		// a local flight is limited by the maximum zoom level
+		{"Berlin", [4]float64{13.38, 52.51, 13.381, 52.511}, DefaultDPI, MaxGridZoom},
+	}
+	for _, c := range cases {
+		grid, err := PlanTiles(c.bbox, source, 480, 480, 0.1, c.dpi)
+		CheckError(t, err)
+		if grid.Z != c.zoom {
+			t.Errorf("%s: zoom level is %d, expected %d", c.name, grid.Z, c.zoom)
+		}
+		bounds := image.Rect(0, 0, grid.Columns*grid.TileSize, grid.Rows*grid.TileSize)
+		if !grid.Section.In(bounds) {
+			t.Errorf("%s: section %v is not covered by the tiles %v", c.name, grid.Section, bounds)
+		}
+		// the section is at most twice as large as required and does not need more than one additional tile
+		required := int(480*c.dpi/DefaultDPI) * 2
+		if maxTiles := required/grid.TileSize + 2; grid.Columns > maxTiles || grid.Rows > maxTiles {
+			t.Errorf("%s: grid of %dx%d tiles is too large", c.name, grid.Columns, grid.Rows)
+		}
+		if len(grid.Tiles()) != grid.Columns*grid.Rows {
+			t.Errorf("%s: number of tiles is not matching the grid", c.name)
+		}
+	}
+
+	// the largest image is only allowed at the default dpi
+	bbox := cases[0].bbox
+	if _, err := PlanTiles(bbox, source, MaxImageSize, MaxImageSize, 0.1, DefaultDPI); err != nil {
+		t.Errorf("Largest image at the default dpi fails: %s", err)
+	}
+	if _, err := PlanTiles(bbox, source, MaxImageSize, MaxImageSize, 0.1, MaxDPI); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Largest image at the maximum dpi is planned: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// ParseIGC reads the fixes of the B records with altitude and time into the track of
+// the flight and the declared task of the C records, the task is nil if none is declared.
+// The time of the fixes is given in seconds since the epoch, or since midnight if the
+// file has no date.
+func ParseIGC(r io.Reader) (*Flight, *Task, error) {
+	var (
+		flight   = &Flight{}
+		declared orb.LineString
+		names    []string
+		date     time.Time
+		previous float64
+		days     float64
+	)
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		switch {
+		case strings.HasPrefix(line, "B"):
+			if len(line) < 35 {
+				return nil, nil, fmt.Errorf("line %d: B record is too short", number)
+			}
+			point, err := parseIGCCoordinate(line[7:24])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			// loggers without position write zeros
+			if point[0] == 0 && point[1] == 0 {
+				continue
+			}
+			seconds, err := parseIGCTime(line[1:7])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			// the time continues after midnight UTC
+			if seconds+days < previous {
+				days += 86400
+			}
+			previous = seconds + days
+			pressure, errPressure := strconv.Atoi(line[25:30])
+			gps, errGPS := strconv.Atoi(line[30:35])
+			if errPressure != nil || errGPS != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid altitude", number)
+			}
+			// the gps altitude is preferred, loggers without gps altitude write zeros
+			altitude := gps
+			if gps == 0 {
+				altitude = pressure
+			}
+			flight.Track.Line = append(flight.Track.Line, point)
+			flight.Track.Alt = append(flight.Track.Alt, float64(altitude))
+			flight.Track.Time = append(flight.Track.Time, previous)
+		case strings.HasPrefix(line, "HFDTE"):
+			value := strings.TrimPrefix(strings.TrimPrefix(line, "HFDTE"), "DATE:")
+			if len(value) < 6 {
+				return nil, nil, fmt.Errorf("line %d: invalid date", number)
+			}
+			var err error
+			if date, err = time.Parse("020106", value[:6]); err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid date %q", number, value[:6])
+			}
+		case strings.HasPrefix(line, "HFPLT"):
+			if i := strings.Index(line, ":"); i >= 0 {
+				flight.Pilot = strings.TrimSpace(line[i+1:])
+			}
+		case strings.HasPrefix(line, "C") && len(line) >= 18 && strings.ContainsAny(line[8:9], "NS") && strings.ContainsAny(line[17:18], "EW"):
+			point, err := parseIGCCoordinate(line[1:18])
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", number, err)
+			}
+			declared = append(declared, point)
+			names = append(names, strings.TrimSpace(line[18:]))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if !date.IsZero() {
+		epoch := float64(date.Unix())
+		for i := range flight.Track.Time {
+			flight.Track.Time[i] += epoch
+		}
+	}
+	return flight, declarationTask(declared, names), nil
+}
+
+// LoadIGC parses the IGC file
+func LoadIGC(FileName string) (*Flight, *Task, error) {
+	file, err := os.Open(FileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	flight, task, err := ParseIGC(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	return flight, task, nil
+}
+
+// declarationTask converts the points of the C records to a task, the declaration starts
+// with the takeoff and ends with the landing, which are not part of the task. The
+// observation zones are not declared, so the start and finish are drawn as lines and
+// the turnpoints as cylinders of the default radius.
+func declarationTask(points orb.LineString, names []string) *Task {
+	task := &Task{}
+	for i, point := range points {
+		if i == 0 || i == len(points)-1 || (point[0] == 0 && point[1] == 0) {
+			continue
+		}
+		task.Turnpoints = append(task.Turnpoints, Turnpoint{Name: names[i], Lon: point[0], Lat: point[1], Type: ZoneCylinder, Radius: DefaultZoneRadius, Angle: DefaultSectorAngle})
+	}
+	if len(task.Turnpoints) < 2 {
+		return nil
+	}
+	task.Turnpoints[0].Type = ZoneLine
+	task.Turnpoints[len(task.Turnpoints)-1].Type = ZoneLine
+	return task
+}
+
+// parseIGCCoordinate parses DDMMmmmNDDDMMmmmE
+func parseIGCCoordinate(value string) (orb.Point, error) {
+	if len(value) != 17 {
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	latDeg, err1 := strconv.Atoi(value[0:2])
+	latMin, err2 := strconv.Atoi(value[2:7])
+	lonDeg, err3 := strconv.Atoi(value[8:11])
+	lonMin, err4 := strconv.Atoi(value[11:16])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	lat := float64(latDeg) + float64(latMin)/60000
+	lon := float64(lonDeg) + float64(lonMin)/60000
+	switch value[7] {
+	case 'S':
+		lat = -lat
+	case 'N':
+	default:
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	switch value[16] {
+	case 'W':
+		lon = -lon
+	case 'E':
+	default:
+		return orb.Point{}, fmt.Errorf("invalid coordinate %q", value)
+	}
+	return orb.Point{lon, lat}, nil
+}
+
+// parseIGCTime returns the seconds since midnight of HHMMSS
+func parseIGCTime(value string) (float64, error) {
+	t, err := time.Parse("150405", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return float64(t.Hour()*3600 + t.Minute()*60 + t.Second()), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fogleman/gg"
+)
+
+func TestParseIGC(t *testing.T) {
+	flight, task, err := LoadIGC("testdata/berlin.igc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := flight.Track
+	if len(track.Line) != 61 || len(track.Alt) != 61 || len(track.Time) != 61 {
+		t.Fatalf("Track has %d fixes", len(track.Line))
+	}
+	if flight.Pilot != "Otto Lilienthal" {
+		t.Errorf("Pilot is %q", flight.Pilot)
+	}
+	if track.Line[0][0] != 13.29 || track.Line[0][1] != 52.56 || track.Alt[0] != 800 {
+		t.Errorf("First fix is %v at %v m", track.Line[0], track.Alt[0])
+	}
+	if start := time.Date(2021, 7, 15, 10, 20, 0, 0, time.UTC); track.Time[0] != float64(start.Unix()) || track.Time[1]-track.Time[0] != 60 {
+		t.Errorf("Time is %v, expected %v", track.Time[0], start.Unix())
+	}
+	// takeoff and landing are not part of the task
+	if task == nil || len(task.Turnpoints) != 4 || task.Turnpoints[1].Name != "Brandenburg" {
+		t.Fatalf("Task is %+v", task)
+	}
+	if task.Turnpoints[0].Type != ZoneLine || task.Turnpoints[1].Type != ZoneCylinder || task.Turnpoints[3].Type != ZoneLine {
+		t.Errorf("Observation zones are %+v", task.Turnpoints)
+	}
+}
+
+func TestParseIGCMidnight(t *testing.T) {
+	igc := "B2359590000000N00000000EA0000000000\r\n" + // without position
+		"B2359585000000N01000000EA0010000000\r\n" +
+		"B0000025000000N01000000EA0010000000\r\n"
+	flight, task, err := ParseIGC(strings.NewReader(igc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task != nil {
+		t.Error("Task is declared")
+	}
+	// without date the time is given since midnight, the pressure altitude is used without gps altitude
+	if len(flight.Track.Time) != 2 || flight.Track.Time[0] != 86398 || flight.Track.Time[1] != 86402 || flight.Track.Alt[0] != 100 {
+		t.Errorf("Track is %+v", flight.Track)
+	}
+}
+
+func TestParseIGCInvalid(t *testing.T) {
+	for _, invalid := range []string{
+		"B102000",
+		"B1020005233600X01317400EA0078000800",
+		"B1020005233600N01317400EA00780abcde",
+		"B9920005233600N01317400EA0078000800",
+		"HFDTE1507",
+	} {
+		if _, _, err := ParseIGC(strings.NewReader("AXXX\r\n" + invalid)); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%q should fail in line 2: %v", invalid, err)
+		}
+	}
+}
+
+// NewSolidTileServer serves the same tile of a single color for any request
+func NewSolidTileServer(t *testing.T, c color.Color) *httptest.Server {
+	dc := gg.NewContext(256, 256)
+	dc.SetColor(c)
+	dc.Clear()
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, dc.Image()); err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(buf.Bytes())
+	}))
+}
+
+func TestRenderIGC(t *testing.T) {
+	background := color.NRGBA{0xf0, 0xf0, 0xf0, 0xff}
+	server := NewSolidTileServer(t, background)
+	defer server.Close()
+	flight, task, err := LoadIGC("testdata/berlin.igc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Options := NewTestOptions(server)
+	Options.Width, Options.Height = 320, 180
+	rendering, err := Render([]*Flight{flight}, task, Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rendering.Image.Bounds() != image.Rect(0, 0, 320, 180) {
+		t.Errorf("Image has size %v", rendering.Image.Bounds())
+	}
+	if len(rendering.Degraded) != 0 {
+		t.Errorf("Tiles are degraded: %v", rendering.Degraded)
+	}
+	// the track is drawn in the center of the image
+	drawn := false
+	for x := 0; x < 320; x++ {
+		if color.NRGBAModel.Convert(rendering.Image.At(x, 90)) != background {
+			drawn = true
+			break
+		}
+	}
+	if !drawn {
+		t.Error("Track is not drawn")
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+)
+
+// Formats of the input files
+const (
+	FormatIGC     string = "igc"
+	FormatGeoJSON string = "geojson"
+	FormatGPX     string = "gpx"
+	FormatKML     string = "kml"
+)
+
+// InputFormat returns the format of the file by its extension
+func InputFormat(FileName string) (string, error) {
+	switch strings.ToLower(filepath.Ext(FileName)) {
+	case ".igc":
+		return FormatIGC, nil
+	case ".geojson", ".json":
+		return FormatGeoJSON, nil
+	case ".gpx":
+		return FormatGPX, nil
+	case ".kml":
+		return FormatKML, nil
+	}
+	return "", fmt.Errorf("unknown format of %s, available: %s, %s, %s, %s", FileName, FormatIGC, FormatGeoJSON, FormatGPX, FormatKML)
+}
+
+// LoadFlights reads the flights of the file, - reads from stdin and requires the format.
+// The task is only declared by IGC files, otherwise it is nil.
+func LoadFlights(FileName string, format string) ([]*Flight, *Task, error) {
+	var err error
+	if format == "" {
+		if format, err = InputFormat(FileName); err != nil {
+			return nil, nil, err
+		}
+	}
+	r := io.Reader(os.Stdin)
+	if FileName != "-" {
+		file, err := os.Open(FileName)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	flights, task, err := ReadFlights(r, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	return flights, task, nil
+}
+
+// ReadFlights reads the flights in the format, every feature, track or placemark is a
+// flight and the parts of multi geometries are joined to one line
+func ReadFlights(r io.Reader, format string) (flights []*Flight, task *Task, err error) {
+	switch format {
+	case FormatIGC:
+		var flight *Flight
+		flight, task, err = ParseIGC(r)
+		flights = []*Flight{flight}
+	case FormatGeoJSON:
+		flights, err = ParseGeoJSON(r)
+	case FormatGPX:
+		flights, err = ParseGPX(r)
+	case FormatKML:
+		flights, err = ParseKML(r)
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// points and polygons are not drawn
+	valid := flights[:0]
+	for _, flight := range flights {
+		if len(flight.Track.Line) > 0 {
+			valid = append(valid, flight)
+		}
+	}
+	if len(valid) == 0 {
+		return nil, nil, fmt.Errorf("%w: no line strings in %s", ErrEmptyGeometry, format)
+	}
+	return valid, task, nil
+}
+
+// trackBuilder collects the fixes of a track, altitude and time are only kept if
+// all fixes have them
+type trackBuilder struct {
+	track       Track
+	missingAlt  bool
+	missingTime bool
+}
+
+func (b *trackBuilder) add(point orb.Point, alt *float64, t *float64) {
+	b.track.Line = append(b.track.Line, point)
+	if alt == nil {
+		b.missingAlt = true
+	} else {
+		b.track.Alt = append(b.track.Alt, *alt)
+	}
+	if t == nil {
+		b.missingTime = true
+	} else {
+		b.track.Time = append(b.track.Time, *t)
+	}
+}
+
+func (b *trackBuilder) build() Track {
+	if b.missingAlt {
+		b.track.Alt = nil
+	}
+	if b.missingTime {
+		b.track.Time = nil
+	}
+	return b.track
+}
+
+// parseTime returns the seconds since the epoch of a RFC 3339 time
+func parseTime(value string) (*float64, error) {
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid time %q", value)
+	}
+	seconds := float64(t.UnixNano()) / 1e9
+	return &seconds, nil
+}
+
+type geoJSONObject struct {
+	Type        string           `json:"type"`
+	Features    []*geoJSONObject `json:"features"`
+	Geometry    *geoJSONObject   `json:"geometry"`
+	Geometries  []*geoJSONObject `json:"geometries"`
+	Coordinates json.RawMessage  `json:"coordinates"`
+	Properties  struct {
+		Name  string `json:"name"`
+		Pilot string `json:"pilot"`
+		// times of the coordinates as written by togeojson
+		CoordTimes json.RawMessage `json:"coordTimes"`
+	} `json:"properties"`
+}
+
+// ParseGeoJSON reads a FeatureCollection, Feature or geometry, the altitude is the third
+// value of the coordinates and the time is read from the coordTimes property
+func ParseGeoJSON(r io.Reader) ([]*Flight, error) {
+	var object geoJSONObject
+	if err := json.NewDecoder(r).Decode(&object); err != nil {
+		return nil, fmt.Errorf("invalid geojson: %w", err)
+	}
+	features := []*geoJSONObject{&object}
+	switch object.Type {
+	case "FeatureCollection":
+		features = object.Features
+	case "Feature":
+	default:
+		// a plain geometry
+		features = []*geoJSONObject{{Type: "Feature", Geometry: &object}}
+	}
+	var flights []*Flight
+	for _, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+		var lines [][][]float64
+		if err := collectGeoJSONLines(feature.Geometry, &lines); err != nil {
+			return nil, err
+		}
+		var times []string
+		if raw := feature.Properties.CoordTimes; len(raw) > 0 {
+			// a line string has a list of times, a multi line string a list of lists
+			var flat []string
+			var nested [][]string
+			if json.Unmarshal(raw, &flat) == nil {
+				times = flat
+			} else if json.Unmarshal(raw, &nested) == nil {
+				for _, part := range nested {
+					times = append(times, part...)
+				}
+			}
+		}
+		hasTimes := len(times) == countCoordinates(lines)
+		var b trackBuilder
+		i := 0
+		for _, line := range lines {
+			for _, coordinate := range line {
+				if len(coordinate) < 2 {
+					return nil, fmt.Errorf("invalid coordinate %v", coordinate)
+				}
+				var alt, t *float64
+				if len(coordinate) > 2 {
+					alt = &coordinate[2]
+				}
+				if hasTimes {
+					var err error
+					if t, err = parseTime(times[i]); err != nil {
+						return nil, err
+					}
+				}
+				b.add(orb.Point{coordinate[0], coordinate[1]}, alt, t)
+				i++
+			}
+		}
+		name := feature.Properties.Pilot
+		if name == "" {
+			name = feature.Properties.Name
+		}
+		flights = append(flights, &Flight{Pilot: name, Track: b.build()})
+	}
+	return flights, nil
+}
+
+// collectGeoJSONLines appends the coordinates of all line strings of the geometry
+func collectGeoJSONLines(geometry *geoJSONObject, lines *[][][]float64) error {
+	switch geometry.Type {
+	case "LineString":
+		var line [][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &line); err != nil {
+			return fmt.Errorf("invalid line string: %w", err)
+		}
+		*lines = append(*lines, line)
+	case "MultiLineString":
+		var multi [][][]float64
+		if err := json.Unmarshal(geometry.Coordinates, &multi); err != nil {
+			return fmt.Errorf("invalid multi line string: %w", err)
+		}
+		*lines = append(*lines, multi...)
+	case "GeometryCollection":
+		for _, g := range geometry.Geometries {
+			if err := collectGeoJSONLines(g, lines); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func countCoordinates(lines [][][]float64) (n int) {
+	for _, line := range lines {
+		n += len(line)
+	}
+	return
+}
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele"`
+	Time string   `xml:"time"`
+}
+
+type gpxFile struct {
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+	Routes []struct {
+		Name   string     `xml:"name"`
+		Points []gpxPoint `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// ParseGPX reads the tracks and routes, the segments of a track are joined
+func ParseGPX(r io.Reader) ([]*Flight, error) {
+	var gpx gpxFile
+	if err := xml.NewDecoder(r).Decode(&gpx); err != nil {
+		return nil, fmt.Errorf("invalid gpx: %w", err)
+	}
+	var flights []*Flight
+	add := func(name string, points []gpxPoint) error {
+		var b trackBuilder
+		for _, point := range points {
+			var t *float64
+			if point.Time != "" {
+				var err error
+				if t, err = parseTime(point.Time); err != nil {
+					return err
+				}
+			}
+			b.add(orb.Point{point.Lon, point.Lat}, point.Ele, t)
+		}
+		flights = append(flights, &Flight{Pilot: name, Track: b.build()})
+		return nil
+	}
+	for _, track := range gpx.Tracks {
+		var points []gpxPoint
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+		if err := add(track.Name, points); err != nil {
+			return nil, err
+		}
+	}
+	for _, route := range gpx.Routes {
+		if err := add(route.Name, route.Points); err != nil {
+			return nil, err
+		}
+	}
+	return flights, nil
+}
+
+type kmlGeometry struct {
+	LineStrings []struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"LineString"`
+	// gx:Track with a time for each coordinate
+	Tracks []struct {
+		When  []string `xml:"when"`
+		Coord []string `xml:"coord"`
+	} `xml:"Track"`
+	MultiGeometry []kmlGeometry `xml:"MultiGeometry"`
+}
+
+type kmlPlacemark struct {
+	Name string `xml:"name"`
+	kmlGeometry
+}
+
+// ParseKML reads the LineStrings and gx:Tracks of all placemarks, the geometries of
+// a placemark are joined
+func ParseKML(r io.Reader) ([]*Flight, error) {
+	decoder := xml.NewDecoder(r)
+	var flights []*Flight
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid kml: %w", err)
+		}
+		// placemarks might be nested in documents and folders
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "Placemark" {
+			continue
+		}
+		var placemark kmlPlacemark
+		if err := decoder.DecodeElement(&placemark, &start); err != nil {
+			return nil, fmt.Errorf("invalid kml: %w", err)
+		}
+		var b trackBuilder
+		if err := collectKMLLines(&placemark.kmlGeometry, &b); err != nil {
+			return nil, err
+		}
+		flights = append(flights, &Flight{Pilot: strings.TrimSpace(placemark.Name), Track: b.build()})
+	}
+	return flights, nil
+}
+
+func collectKMLLines(geometry *kmlGeometry, b *trackBuilder) error {
+	for _, line := range geometry.LineStrings {
+		// tuples of lon,lat[,alt] separated by whitespace
+		for _, tuple := range strings.Fields(line.Coordinates) {
+			values, err := parseFloats(strings.Split(tuple, ","))
+			if err != nil || len(values) < 2 {
+				return fmt.Errorf("invalid coordinate %q", tuple)
+			}
+			var alt *float64
+			if len(values) > 2 {
+				alt = &values[2]
+			}
+			b.add(orb.Point{values[0], values[1]}, alt, nil)
+		}
+	}
+	for _, track := range geometry.Tracks {
+		for i, coord := range track.Coord {
+			// lon lat alt separated by spaces
+			values, err := parseFloats(strings.Fields(coord))
+			if err != nil || len(values) < 2 {
+				return fmt.Errorf("invalid coordinate %q", coord)
+			}
+			var alt, t *float64
+			if len(values) > 2 {
+				alt = &values[2]
+			}
+			if len(track.When) == len(track.Coord) {
+				if t, err = parseTime(track.When[i]); err != nil {
+					return err
+				}
+			}
+			b.add(orb.Point{values[0], values[1]}, alt, t)
+		}
+	}
+	for i := range geometry.MultiGeometry {
+		if err := collectKMLLines(&geometry.MultiGeometry[i], b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		var err error
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
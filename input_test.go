@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFlights(t *testing.T) {
+	start := float64(time.Date(2021, 7, 15, 10, 20, 0, 0, time.UTC).Unix())
+	for _, file := range []string{"testdata/berlin.geojson", "testdata/berlin.gpx", "testdata/berlin.kml"} {
+		flights, task, err := LoadFlights(file, "")
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		if task != nil {
+			t.Errorf("%s: task is declared", file)
+		}
+		// points are skipped, the gpx route is a flight on its own
+		if len(flights) != 2 {
+			t.Errorf("%s: %d flights", file, len(flights))
+			continue
+		}
+		first, second := flights[0], flights[1]
+		if first.Pilot != "Otto Lilienthal" || len(first.Track.Line) != 3 || first.Track.Line[2][0] != 13.21 {
+			t.Errorf("%s: first flight is %+v", file, first)
+		}
+		if len(first.Track.Alt) != 3 || first.Track.Alt[2] != 900 || len(first.Track.Time) != 3 || first.Track.Time[0] != start || first.Track.Time[2] != start+120 {
+			t.Errorf("%s: altitude %v and time %v of the first flight", file, first.Track.Alt, first.Track.Time)
+		}
+		// without altitude and time the track consists only of the line
+		if len(second.Track.Line) < 2 || len(second.Track.Time) != 0 {
+			t.Errorf("%s: second flight is %+v", file, second)
+		}
+	}
+}
+
+func TestLoadFlightsMultiGeometry(t *testing.T) {
+	for _, file := range []string{"testdata/berlin.geojson", "testdata/berlin.kml"} {
+		flights, _, err := LoadFlights(file, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the parts are joined to one line
+		if line := flights[1].Track.Line; len(line) != 4 || line[3][1] != 52.93 {
+			t.Errorf("%s: multi line string is %v", file, line)
+		}
+	}
+}
+
+func TestReadFlightsGeometry(t *testing.T) {
+	flights, _, err := ReadFlights(strings.NewReader(`{"type": "LineString", "coordinates": [[1, 2], [3, 4]]}`), FormatGeoJSON)
+	if err != nil || len(flights) != 1 || len(flights[0].Track.Line) != 2 {
+		t.Errorf("Plain geometry is not read: %v %v", flights, err)
+	}
+	if _, _, err := ReadFlights(strings.NewReader(`{"type": "Point", "coordinates": [1, 2]}`), FormatGeoJSON); !errors.Is(err, ErrEmptyGeometry) {
+		t.Errorf("Point should result in an empty geometry: %v", err)
+	}
+	for format, invalid := range map[string]string{
+		FormatGeoJSON: `{"type": "LineString", "coordinates": [[1], [3, 4]]}`,
+		FormatGPX:     `<gpx><trk><trkseg><trkpt lat="1" lon="2"><time>noon</time></trkpt></trkseg></trk></gpx>`,
+		FormatKML:     `<kml><Placemark><LineString><coordinates>1,a</coordinates></LineString></Placemark></kml>`,
+		"csv":         ``,
+	} {
+		if _, _, err := ReadFlights(strings.NewReader(invalid), format); err == nil {
+			t.Errorf("%s should be invalid", format)
+		}
+	}
+}
+
+func TestInputFormat(t *testing.T) {
+	for file, expected := range map[string]string{"a.IGC": FormatIGC, "a.json": FormatGeoJSON, "a.geojson": FormatGeoJSON, "a.gpx": FormatGPX, "a.kml": FormatKML} {
+		if format, err := InputFormat(file); err != nil || format != expected {
+			t.Errorf("Format of %s is %q: %v", file, format, err)
+		}
+	}
+	if _, err := InputFormat("a.kmz"); err == nil {
+		t.Error("kmz should be unknown")
+	}
+}
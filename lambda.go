@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// LambdaEvent is the payload the lambda function is invoked with
+type LambdaEvent struct {
+	FlightID  uint   `json:"flight_id"`
+	FlightIDs []uint `json:"flight_ids"`
+	// Combine renders all flights into one image instead of one image per flight
+	Combine bool `json:"combine"`
+	// DayID renders all flights of the competition day into one image
+	DayID uint `json:"day_id"`
+
+	Thickness float64 `json:"thickness"`
+	// Width and Height of the image in pixels, Size is a shorthand for square images,
+	// 0 uses the size of the cli
+	Size   int `json:"size"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// DPI of the image, 0 uses the dpi of the cli
+	DPI float64 `json:"dpi"`
+	// Bucket the images are uploaded to, if empty the images are returned base64 encoded
+	Bucket string `json:"bucket"`
+	// Prefix for the object keys
+	Prefix string `json:"prefix"`
+	// Tiles selects a built-in tile source, the default is the source of the cli
+	Tiles string `json:"tiles"`
+	// ColorBy colors the track by altitude, speed or vario
+	ColorBy string `json:"color_by"`
+	// Palette is a built-in palette or comma separated colors
+	Palette string `json:"palette"`
+	// Legend, Task and AirportLabel override the cli only if they are set, Task draws
+	// the declared task of the flight
+	Legend *bool `json:"legend"`
+	Task   *bool `json:"task"`
+	// StartMarker and LandingMarker are shapes like circle or flag
+	StartMarker   string `json:"start_marker"`
+	LandingMarker string `json:"landing_marker"`
+	AirportLabel  *bool  `json:"airport_label"`
+}
+
+// LambdaImage is the result for a single image
+type LambdaImage struct {
+	FlightID uint `json:"flight_id,omitempty"`
+	// FlightIDs are set instead of FlightID for combined images
+	FlightIDs []uint `json:"flight_ids,omitempty"`
+	DayID     uint   `json:"day_id,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Degraded lists the tiles that were replaced by the fallback
+	Degraded []string `json:"degraded,omitempty"`
+}
+
+// LambdaResponse is returned by the lambda function
+type LambdaResponse struct {
+	Images []LambdaImage `json:"images"`
+}
+
+// IDs returns all flight ids of the event
+func (e *LambdaEvent) IDs() []uint {
+	if e.FlightID == 0 {
+		return e.FlightIDs
+	}
+	return append([]uint{e.FlightID}, e.FlightIDs...)
+}
+
+// Options returns the defaults overridden by the event
+func (e *LambdaEvent) Options(Defaults RenderOptions) (RenderOptions, error) {
+	Options := Defaults
+	if e.Thickness > 0 {
+		Options.Line.Width = e.Thickness
+	}
+	for _, size := range []struct {
+		value  int
+		target []*int
+	}{
+		{e.Size, []*int{&Options.Width, &Options.Height}},
+		{e.Width, []*int{&Options.Width}},
+		{e.Height, []*int{&Options.Height}},
+	} {
+		if size.value == 0 {
+			continue
+		}
+		if !ValidSize(size.value) {
+			return Options, fmt.Errorf("width and height have to be between 1 and %d pixels", MaxImageSize)
+		}
+		for _, target := range size.target {
+			*target = size.value
+		}
+	}
+	if e.DPI < 0 || e.DPI > MaxDPI {
+		return Options, fmt.Errorf("dpi has to be between 0 and %.0f", MaxDPI)
+	} else if e.DPI > 0 {
+		Options.DPI = e.DPI
+	}
+	if err := CheckPixels(Options.Width, Options.Height, Options.DPI); err != nil {
+		return Options, err
+	}
+	if e.Tiles != "" {
+		source, err := LoadTileSource(e.Tiles, "")
+		if err != nil {
+			return Options, err
+		}
+		Options.Source = source
+	}
+	if e.ColorBy != "" {
+		if !ValidColorBy(e.ColorBy) {
+			return Options, fmt.Errorf("unknown attribute %q", e.ColorBy)
+		}
+		Options.ColorBy = e.ColorBy
+	}
+	if e.Palette != "" {
+		palette, err := ParsePalette(e.Palette)
+		if err != nil {
+			return Options, err
+		}
+		Options.Palette = palette
+	}
+	for _, flag := range []struct {
+		value  *bool
+		target *bool
+	}{
+		{e.Legend, &Options.Legend},
+		{e.Task, &Options.ShowTask},
+		{e.AirportLabel, &Options.AirportLabel},
+	} {
+		if flag.value != nil {
+			*flag.target = *flag.value
+		}
+	}
+	for _, marker := range []struct {
+		value  string
+		target **Marker
+		color  color.NRGBA
+	}{
+		{e.StartMarker, &Options.StartMarker, StartColor},
+		{e.LandingMarker, &Options.LandingMarker, LandingColor},
+	} {
+		if marker.value == "" {
+			continue
+		}
+		// icons would be read from the file system of the lambda function
+		if !ValidMarkerShape(marker.value) {
+			return Options, fmt.Errorf("unknown marker %q", marker.value)
+		}
+		*marker.target, _ = ParseMarker(marker.value, Options.MarkerSize, marker.color)
+	}
+	return Options, nil
+}
+
+// NewLambdaHandler returns the lambda handler rendering with the given default options
+func NewLambdaHandler(Source FlightSource, Defaults RenderOptions) func(context.Context, LambdaEvent) (LambdaResponse, error) {
+	return func(ctx context.Context, event LambdaEvent) (LambdaResponse, error) {
+		return HandleRequest(ctx, event, Source, Defaults)
+	}
+}
+
+// HandleRequest renders all flights of the event and uploads them, a failing flight
+// does not abort the other ones but is reported in the response
+func HandleRequest(ctx context.Context, event LambdaEvent, Source FlightSource, Defaults RenderOptions) (LambdaResponse, error) {
+	var response LambdaResponse
+	ids := event.IDs()
+	if len(ids) == 0 && event.DayID == 0 {
+		return response, fmt.Errorf("no flight ids in event")
+	}
+	Options, err := event.Options(Defaults)
+	if err != nil {
+		return response, err
+	}
+	var client *s3.S3
+	if event.Bucket != "" {
+		sess, err := NewSession()
+		if err != nil {
+			return response, err
+		}
+		client = s3.New(sess)
+	}
+
+	// every image is rendered from one or multiple flights
+	var images []LambdaImage
+	switch {
+	case event.DayID != 0:
+		images = append(images, LambdaImage{DayID: event.DayID})
+	case event.Combine:
+		images = append(images, LambdaImage{FlightIDs: ids})
+	default:
+		for _, FlightID := range ids {
+			images = append(images, LambdaImage{FlightID: FlightID})
+		}
+	}
+
+	for _, result := range images {
+		FlightIDs := result.FlightIDs
+		var err error
+		if result.FlightID != 0 {
+			FlightIDs = []uint{result.FlightID}
+		} else if result.DayID != 0 {
+			FlightIDs, err = Source.CompetitionDay(ctx, result.DayID)
+		}
+		log.Printf("Processing Flight IDs %v\n", FlightIDs)
+		var body []byte
+		if err == nil {
+			body, result.Degraded, err = encodeFlights(ctx, Source, FlightIDs, Options)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		} else if client == nil {
+			result.Image = base64.StdEncoding.EncodeToString(body)
+		} else {
+			result.Key = event.Prefix + result.name()
+			_, err = client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String(event.Bucket),
+				Key:         aws.String(result.Key),
+				Body:        bytes.NewReader(body),
+				ContentType: aws.String("image/png"),
+			})
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		response.Images = append(response.Images, result)
+	}
+	return response, nil
+}
+
+// name returns the file name of the image
+func (i *LambdaImage) name() string {
+	switch {
+	case i.DayID != 0:
+		return fmt.Sprintf("Day_%d.png", i.DayID)
+	case len(i.FlightIDs) > 0:
+		return fmt.Sprintf("Flights_%d.png", i.FlightIDs[0])
+	}
+	return fmt.Sprintf("Flight_%d.png", i.FlightID)
+}
+
+func encodeFlights(ctx context.Context, Source FlightSource, FlightIDs []uint, Options RenderOptions) ([]byte, []string, error) {
+	rendering, err := RenderFlights(ctx, Source, FlightIDs, Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, rendering.Image); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrEncode, err)
+	}
+	degraded := make([]string, len(rendering.Degraded))
+	for i, tile := range rendering.Degraded {
+		degraded[i] = tile.Error()
+	}
+	return buf.Bytes(), degraded, nil
+}
+
+// NewSession creates the aws session, S3_ENDPOINT allows to use a local S3 compatible
+// storage like minio instead of aws
+func NewSession() (*session.Session, error) {
+	config := aws.NewConfig()
+	if endpoint := os.Getenv("S3_ENDPOINT"); endpoint != "" {
+		config = config.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+		if os.Getenv("AWS_REGION") == "" {
+			config = config.WithRegion("us-east-1")
+		}
+	}
+	return session.NewSession(config)
+}
+
+// InvokeLocal runs the handler with the event read from the given file
+// and prints the response, this mimics an invocation by aws
+func InvokeLocal(FileName string, Source FlightSource, Defaults RenderOptions) error {
+	content, err := ioutil.ReadFile(FileName)
+	if err != nil {
+		return err
+	}
+	var event LambdaEvent
+	if err := json.Unmarshal(content, &event); err != nil {
+		return fmt.Errorf("invalid event %s: %w", FileName, err)
+	}
+	response, err := HandleRequest(context.Background(), event, Source, Defaults)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(response)
+}
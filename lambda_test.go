@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"image/color"
+	"testing"
+)
+
+func TestHandleRequest(t *testing.T) {
+	server := NewSolidTileServer(t, color.White)
+	defer server.Close()
+	event := LambdaEvent{FlightIDs: []uint{1, 4}, Size: 64}
+	response, err := HandleRequest(context.Background(), event, NewTestSource(), NewTestOptions(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Images) != 2 {
+		t.Fatalf("Response has %d images", len(response.Images))
+	}
+	// a failing flight does not abort the others
+	content, err := base64.StdEncoding.DecodeString(response.Images[0].Image)
+	if err != nil || len(content) == 0 || response.Images[0].Error != "" {
+		t.Errorf("Image is not returned: %v %s", err, response.Images[0].Error)
+	}
+	if response.Images[1].Error == "" {
+		t.Error("Unknown flight should fail")
+	}
+
+	event = LambdaEvent{DayID: 10, Size: 64}
+	response, err = HandleRequest(context.Background(), event, NewTestSource(), NewTestOptions(server))
+	if err != nil || len(response.Images) != 1 || response.Images[0].Error != "" {
+		t.Errorf("Competition day is not rendered: %+v %v", response, err)
+	}
+
+	// unset flags keep the cli defaults, set flags override them in both directions
+	Defaults := NewTestOptions(server)
+	Defaults.Legend, Defaults.ShowTask = true, true
+	var parsed LambdaEvent
+	if err := json.Unmarshal([]byte(`{"flight_id": 1, "legend": false, "airport_label": true}`), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	Options, err := parsed.Options(Defaults)
+	if err != nil || Options.Legend || !Options.ShowTask || !Options.AirportLabel {
+		t.Errorf("Flags of the event are not applied: legend %t, task %t, airport label %t, %v", Options.Legend, Options.ShowTask, Options.AirportLabel, err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Blend modes of the layers of a stack
+const (
+	BlendNormal   string = "normal"
+	BlendMultiply string = "multiply"
+	BlendScreen   string = "screen"
+	BlendOverlay  string = "overlay"
+	BlendDarken   string = "darken"
+	BlendLighten  string = "lighten"
+)
+
+// BlendModes are the available blend modes
+var BlendModes = []string{BlendNormal, BlendMultiply, BlendScreen, BlendOverlay, BlendDarken, BlendLighten}
+
+// TileRenderer is implemented by tile sources that compose their tiles from other
+// sources instead of downloading them
+type TileRenderer interface {
+	RenderTile(cache *TileCache, z int16, x int16, y int16) (image.Image, error)
+}
+
+// Layer is one source of a layer stack
+type Layer struct {
+	Source TileSource
+	// Opacity between 0 and 1
+	Opacity float64
+	Blend   string
+	// MinZoom and MaxZoom restrict the zoom levels the layer is drawn on, a MaxZoom
+	// of 0 draws the layer on all levels from MinZoom
+	MinZoom int16
+	MaxZoom int16
+}
+
+// LayerConfig is the json definition of a layer, the source is either the name of
+// a built-in source or the definition of a custom source
+type LayerConfig struct {
+	Source  string            `json:"source"`
+	Tiles   *TileSourceConfig `json:"tiles"`
+	Opacity *float64          `json:"opacity"`
+	Blend   string            `json:"blend"`
+	MinZoom int16             `json:"min_zoom"`
+	MaxZoom int16             `json:"max_zoom"`
+}
+
+// Layer creates the layer of the config
+func (c *LayerConfig) Layer() (*Layer, error) {
+	layer := &Layer{Opacity: 1, Blend: BlendNormal, MinZoom: c.MinZoom, MaxZoom: c.MaxZoom}
+	switch {
+	case c.Tiles != nil:
+		source, err := c.Tiles.Source()
+		if err != nil {
+			return nil, err
+		}
+		layer.Source = source
+	case c.Source != "":
+		source, ok := TileSources[c.Source]
+		if !ok {
+			return nil, fmt.Errorf("unknown tile source %q, available: %s", c.Source, strings.Join(TileSourceNames(), ", "))
+		}
+		layer.Source = source
+	default:
+		return nil, fmt.Errorf("layer requires a source or tiles")
+	}
+	if c.Opacity != nil {
+		if *c.Opacity < 0 || *c.Opacity > 1 {
+			return nil, fmt.Errorf("opacity of layer %s has to be between 0 and 1", layer.Source.Name())
+		}
+		layer.Opacity = *c.Opacity
+	}
+	if c.Blend != "" {
+		layer.Blend = strings.ToLower(c.Blend)
+	}
+	if !ValidBlendMode(layer.Blend) {
+		return nil, fmt.Errorf("unknown blend mode %q, available: %s", c.Blend, strings.Join(BlendModes, ", "))
+	}
+	return layer, nil
+}
+
+// ValidBlendMode returns whether the blend mode is available
+func ValidBlendMode(mode string) bool {
+	for _, m := range BlendModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns whether the layer is drawn on the zoom level
+func (l *Layer) visible(z int16) bool {
+	return z >= l.MinZoom && (l.MaxZoom == 0 || z <= l.MaxZoom)
+}
+
+// LayerStack composites the tiles of its layers in order, e.g. a hypsometric raster
+// underneath a hillshade and vector roads on top. The layers are composited per tile,
+// so the tiles of each layer are cached on their own.
+type LayerStack struct {
+	ID     string
+	Layers []*Layer
+	Size   int
+}
+
+func (s *LayerStack) Name() string                         { return s.ID }
+func (s *LayerStack) URL(z int16, x int16, y int16) string { return "" }
+func (s *LayerStack) TileSize() int                        { return s.Size }
+func (s *LayerStack) Format() string                       { return "png" }
+
+// MaxZoom is the highest zoom level of any layer, layers with a lower maximum are upscaled
+func (s *LayerStack) MaxZoom() int16 {
+	var zoom int16
+	for _, layer := range s.Layers {
+		max := layer.Source.MaxZoom()
+		if layer.MaxZoom > 0 && layer.MaxZoom < max {
+			max = layer.MaxZoom
+		}
+		if max > zoom {
+			zoom = max
+		}
+	}
+	return zoom
+}
+
+// Attribution joins the distinct attributions of the layers
+func (s *LayerStack) Attribution() string {
+	var attributions []string
+	seen := make(map[string]bool)
+	for _, layer := range s.Layers {
+		if a := layer.Source.Attribution(); a != "" && !seen[a] {
+			attributions = append(attributions, a)
+			seen[a] = true
+		}
+	}
+	return strings.Join(attributions, ", ")
+}
+
+// RenderTile fetches the tile of each visible layer and blends it onto the layers
+// beneath, a failing layer fails the whole tile so that the fallback replaces it
+func (s *LayerStack) RenderTile(cache *TileCache, z int16, x int16, y int16) (image.Image, error) {
+	tile := image.NewRGBA(image.Rect(0, 0, s.Size, s.Size))
+	for _, layer := range s.Layers {
+		if !layer.visible(z) {
+			continue
+		}
+		im, err := layer.fetch(cache, z, x, y)
+		if err != nil {
+			return nil, err
+		}
+		if im.Bounds().Dx() != s.Size || im.Bounds().Dy() != s.Size {
+			scaled := image.NewRGBA(tile.Bounds())
+			draw.CatmullRom.Scale(scaled, scaled.Bounds(), im, im.Bounds(), draw.Src, nil)
+			im = scaled
+		}
+		Blend(tile, im, layer.Blend, layer.Opacity)
+	}
+	return tile, nil
+}
+
+// fetch returns the tile of the layer, zoom levels beyond the maximum of the source
+// are upscaled from the tile of the maximum zoom level
+func (l *Layer) fetch(cache *TileCache, z int16, x int16, y int16) (image.Image, error) {
+	levels := z - l.Source.MaxZoom()
+	if levels <= 0 {
+		im, err := fetchTile(cache, l.Source, z, x, y)
+		if err != nil {
+			return nil, err
+		}
+		return im, nil
+	}
+	parent, err := fetchTile(cache, l.Source, z-levels, x>>uint(levels), y>>uint(levels))
+	if err != nil {
+		return nil, err
+	}
+	return upscaleQuadrant(parent, x, y, levels, l.Source.TileSize()), nil
+}
+
+// Blend draws src onto dst with the blend mode and opacity. The blend mode mixes
+// the colors where both images are opaque, like the blend modes of image editors.
+func Blend(dst *image.RGBA, src image.Image, mode string, opacity float64) {
+	bounds := dst.Bounds().Intersect(src.Bounds())
+	if mode == BlendNormal {
+		mask := image.NewUniform(color.Alpha{uint8(math.Round(opacity * 0xff))})
+		draw.DrawMask(dst, bounds, src, bounds.Min, mask, image.Point{}, draw.Over)
+		return
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sr, sg, sb, sa := src.At(x, y).RGBA()
+			if sa == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			d := dst.Pix[i : i+4 : i+4]
+			// colors are blended without premultiplied alpha
+			as, ab := float64(sa)/0xffff*opacity, float64(d[3])/0xff
+			source := [3]float64{float64(sr) / float64(sa), float64(sg) / float64(sa), float64(sb) / float64(sa)}
+			alpha := as + ab*(1-as)
+			for c := 0; c < 3; c++ {
+				cb := 0.0
+				if d[3] > 0 {
+					cb = float64(d[c]) / float64(d[3])
+				}
+				cs := (1-ab)*source[c] + ab*blendChannel(mode, cb, source[c])
+				// source over, stored with premultiplied alpha
+				d[c] = uint8(math.Round((as*cs + (1-as)*ab*cb) * 0xff))
+			}
+			d[3] = uint8(math.Round(alpha * 0xff))
+		}
+	}
+}
+
+// blendChannel mixes the backdrop and the source channel, both between 0 and 1
+func blendChannel(mode string, cb float64, cs float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return cb * cs
+	case BlendScreen:
+		return cb + cs - cb*cs
+	case BlendOverlay:
+		if cb <= 0.5 {
+			return 2 * cb * cs
+		}
+		return 1 - 2*(1-cb)*(1-cs)
+	case BlendDarken:
+		return math.Min(cb, cs)
+	case BlendLighten:
+		return math.Max(cb, cs)
+	}
+	return cs
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// WriteSolidTile stores a tile of a single color as png in dir/name/z/x/y.png
+func WriteSolidTile(t *testing.T, dir string, name string, z int, x int, y int, c color.Color) {
+	path := filepath.Join(dir, name, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png")
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatal(err)
+	}
+	im := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i := 0; i < len(im.Pix); i += 4 {
+		r, g, b, a := c.RGBA()
+		im.Pix[i], im.Pix[i+1], im.Pix[i+2], im.Pix[i+3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+	}
+	fo, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fo.Close()
+	if err := png.Encode(fo, im); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayerStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// the base has no tiles beyond zoom level 3
+	WriteSolidTile(t, dir, "base", 3, 1, 1, color.RGBA{200, 100, 50, 255})
+	WriteSolidTile(t, dir, "shade", 5, 4, 4, color.RGBA{128, 128, 128, 255})
+	WriteSolidTile(t, dir, "shade", 3, 1, 1, color.RGBA{128, 128, 128, 255})
+	local := func(name string, zoom int16) *TileSourceConfig {
+		return &TileSourceConfig{Name: name, URL: filepath.Join(dir, name, "{z}", "{x}", "{y}.png"), MaxZoom: zoom}
+	}
+	half := 0.5
+	config := TileSourceConfig{Name: "hybrid", Layers: []LayerConfig{
+		{Tiles: local("base", 3)},
+		{Tiles: local("shade", 5), Blend: BlendMultiply, MinZoom: 4},
+		{Tiles: local("shade", 5), Opacity: &half, MaxZoom: 3},
+	}}
+	source, err := config.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.MaxZoom() != 5 || source.TileSize() != 256 {
+		t.Errorf("Stack has max zoom %d and tile size %d", source.MaxZoom(), source.TileSize())
+	}
+
+	cache := NewTileCache("", time.Hour, 0)
+	// the base is upscaled and the shade is multiplied
+	im, tileErr := fetchTile(cache, source, 5, 4, 4)
+	if tileErr != nil {
+		t.Fatal(tileErr)
+	}
+	if c := color.RGBAModel.Convert(im.At(128, 128)).(color.RGBA); !closeTo(c, color.RGBA{100, 50, 25, 255}) {
+		t.Errorf("Multiplied tile is %v", c)
+	}
+	// on zoom level 3 the shade is drawn with half opacity instead
+	im, tileErr = fetchTile(cache, source, 3, 1, 1)
+	if tileErr != nil {
+		t.Fatal(tileErr)
+	}
+	if c := color.RGBAModel.Convert(im.At(128, 128)).(color.RGBA); !closeTo(c, color.RGBA{164, 114, 89, 255}) {
+		t.Errorf("Tile with half transparent layer is %v", c)
+	}
+	// a missing tile of any layer fails the tile
+	if _, tileErr := fetchTile(cache, source, 5, 5, 4); tileErr == nil {
+		t.Errorf("Tile with a missing layer does not fail")
+	}
+
+	config.Layers[1].Blend = "dissolve"
+	if _, err := config.Source(); err == nil {
+		t.Errorf("Unknown blend mode is accepted")
+	}
+}
+
+func TestBlend(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	src := image.NewUniform(color.RGBA{255, 255, 255, 255})
+	for mode, expect := range map[string]color.RGBA{
+		BlendMultiply: {51, 102, 153, 255},
+		BlendScreen:   {255, 255, 255, 255},
+		BlendDarken:   {51, 102, 153, 255},
+		BlendLighten:  {255, 255, 255, 255},
+		BlendOverlay:  {51, 102, 211, 255},
+	} {
+		dst.Pix = []uint8{51, 102, 153, 255}
+		if mode == BlendOverlay {
+			// overlay keeps dark colors darker and light colors lighter
+			src = image.NewUniform(color.RGBA{128, 128, 200, 255})
+		} else {
+			src = image.NewUniform(color.RGBA{255, 255, 255, 255})
+		}
+		Blend(dst, src, mode, 1)
+		if c := dst.RGBAAt(0, 0); !closeTo(c, expect) {
+			t.Errorf("Blending with %s results in %v instead of %v", mode, c, expect)
+		}
+	}
+	// a transparent backdrop takes the color of the source
+	dst.Pix = []uint8{0, 0, 0, 0}
+	Blend(dst, image.NewUniform(color.RGBA{10, 20, 30, 255}), BlendMultiply, 1)
+	if c := dst.RGBAAt(0, 0); c != (color.RGBA{10, 20, 30, 255}) {
+		t.Errorf("Blending onto transparent backdrop results in %v", c)
+	}
+}
+
+// closeTo compares the colors with a tolerance for rounding
+func closeTo(a color.RGBA, b color.RGBA) bool {
+	near := func(x uint8, y uint8) bool { return x-y <= 2 || y-x <= 2 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && near(a.A, b.A)
+}
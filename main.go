@@ -1,23 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
 	"log"
 	"math"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/fogleman/gg"
-	"github.com/lib/pq"
-	"github.com/oliamb/cutter"
 
-	"github.com/paulmach/orb"
-	"github.com/paulmach/orb/encoding/wkb"
-	"github.com/paulmach/orb/geojson"
 	"github.com/urfave/cli/v2"
 
 	"os"
+	"path/filepath"
 )
 
 const (
@@ -25,34 +29,42 @@ const (
 	ColorRed   float64 = 45.0 / ColorScale
 	ColorGreen float64 = 85.0 / ColorScale
 	ColorBlue  float64 = 166.0 / ColorScale
-	TileSize   float64 = 2048.0
-	// e.g. 0.1 means 10 % larger bbox
+	// e.g. 0.1 means 10 % padding on each side of the bbox
 	BufferforCropping float64 = 0.1
 	ImageSize         int     = 480
-	URLPrefix         string  = "https://maptiles.glidercheck.com/hypsometric"
+	// upper limit for the width and height of an image
+	MaxImageSize int    = 4096
+	URLPrefix    string = "https://maptiles.glidercheck.com/hypsometric"
 )
 
 func main() {
 	var (
-		FlightID        uint
-		CircleThickness float64
-		Prefix          string
+		FlightID uint
+		DayID    uint
+		IDs      string
+		Prefix   string
+		Options  RenderOptions
+		// the flights of the commands besides render are read from the db
+		Source FlightSource
 	)
 
 	app := &cli.App{
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.UintFlag{
 				Name:        "id",
 				Value:       1,
 				Usage:       "Flight ID to pe processed",
 				Destination: &FlightID,
 			},
-			&cli.Float64Flag{
-				Name:        "thickness",
-				Value:       1.0,
-				Aliases:     []string{"th"},
-				Usage:       "Thinkness of the line string",
-				Destination: &CircleThickness,
+			&cli.StringFlag{
+				Name:        "ids",
+				Usage:       "Comma separated flight IDs rendered into one image, overrides id",
+				Destination: &IDs,
+			},
+			&cli.UintFlag{
+				Name:        "day",
+				Usage:       "Competition day ID, all flights of the day are rendered into one image",
+				Destination: &DayID,
 			},
 			&cli.StringFlag{
 				Name:        "prefix",
@@ -61,108 +73,551 @@ func main() {
 				Usage:       "Prefix for filename",
 				Destination: &Prefix,
 			},
+		}, append(RenderFlags(), DatabaseFlags()...)...),
+		// options shared by all commands
+		Before: func(c *cli.Context) (err error) {
+			if Options, err = ParseRenderOptions(c); err != nil {
+				return
+			}
+			// the pool connects on the first query, so render does not require a db
+			Source, err = ParsePostgresSource(c)
+			return
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "serve",
+				Usage: "Serve rendered flight images via http on /flights/{id}.png",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "addr",
+						Value:   ":8080",
+						Usage:   "Address the http server listens on",
+						EnvVars: []string{"CASPER_ADDR"},
+					},
+					&cli.DurationFlag{
+						Name:    "render-timeout",
+						Value:   DefaultRenderTimeout,
+						Usage:   "Timeout of the rendering of a request",
+						EnvVars: []string{"CASPER_RENDER_TIMEOUT"},
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Duration("render-timeout") <= 0 {
+						return fmt.Errorf("render timeout has to be positive")
+					}
+					server := NewServer(c.String("addr"), Source, Options, c.Duration("render-timeout"))
+					log.Printf("Listening on %s\n", server.Addr)
+					return server.ListenAndServe()
+				},
+			},
+			{
+				Name:  "render",
+				Usage: "Render flights from a file or stdin without database",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "input",
+						Aliases: []string{"i"},
+						Usage:   "Path to an IGC, GeoJSON, GPX or KML file, - reads from stdin",
+					},
+					&cli.StringFlag{
+						Name:  "igc",
+						Usage: "Path to an IGC file, shorthand for --input with --format igc",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Format of the input: igc, geojson, gpx or kml, detected by the extension if empty",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Usage:   "Path of the image, defaults to the name of the input with .png",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					input, format := c.String("input"), c.String("format")
+					if c.String("igc") != "" {
+						input, format = c.String("igc"), FormatIGC
+					}
+					if input == "" {
+						return fmt.Errorf("either input or igc is required")
+					}
+					output := c.String("output")
+					if output == "" && input == "-" {
+						output = "flight.png"
+					} else if output == "" {
+						output = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)) + ".png"
+					}
+					return PlotFile(c.Context, input, format, Options, output)
+				},
+			},
+			{
+				Name:      "batch",
+				Usage:     "Render many flights of the db into a directory, an interrupted batch is resumed from its journal",
+				ArgsUsage: "[flight ids...]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "file",
+						Aliases: []string{"f"},
+						Usage:   "File with flight ids separated by commas or lines, - reads from stdin",
+					},
+					&cli.StringFlag{
+						Name:  "where",
+						Usage: "SQL condition selecting the flights, e.g. \"created >= '2021-01-01' AND thumbnail IS NULL\"",
+					},
+					&cli.IntFlag{
+						Name:    "workers",
+						Aliases: []string{"w"},
+						Value:   runtime.NumCPU(),
+						Usage:   "Number of flights rendered in parallel",
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   ".",
+						Usage:   "Directory of the images",
+					},
+					&cli.StringFlag{
+						Name:  "journal",
+						Usage: "Json lines file with the result of each flight, defaults to batch.jsonl in the output directory",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					var ids []uint
+					if c.NArg() > 0 {
+						var err error
+						if ids, err = ParseFlightIDs(strings.Join(c.Args().Slice(), ",")); err != nil {
+							return err
+						}
+					}
+					if file := c.String("file"); file != "" {
+						read, err := LoadFlightIDs(file)
+						if err != nil {
+							return err
+						}
+						ids = append(ids, read...)
+					}
+					if where := c.String("where"); where != "" {
+						filtered, err := Source.(*PostgresSource).FilterFlights(c.Context, where)
+						if err != nil {
+							return err
+						}
+						ids = append(ids, filtered...)
+					}
+					if len(ids) == 0 {
+						return fmt.Errorf("%w: either flight ids, file or where is required", ErrFlightNotFound)
+					}
+					journal := c.String("journal")
+					if journal == "" {
+						journal = filepath.Join(c.String("output"), "batch.jsonl")
+					}
+					batch := &Batch{
+						Source:    Source,
+						Options:   Options,
+						Workers:   c.Int("workers"),
+						OutputDir: c.String("output"),
+						Prefix:    Prefix,
+						Journal:   journal,
+					}
+					// on interrupt no further flights are started, the pending flights are rendered by the next run
+					ctx, cancel := InterruptContext(c.Context)
+					defer cancel()
+					_, err := batch.Run(ctx, ids)
+					return err
+				},
+			},
+			{
+				Name:  "worker",
+				Usage: "Render the jobs of a queue table in the db, new jobs are announced by notifications",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "table",
+						Value:   DefaultJobTable,
+						Usage:   "Table of the render jobs",
+						EnvVars: []string{"CASPER_JOB_TABLE"},
+					},
+					&cli.StringFlag{
+						Name:    "channel",
+						Value:   DefaultJobTable,
+						Usage:   "Channel notified about new jobs, empty only polls the table",
+						EnvVars: []string{"CASPER_JOB_CHANNEL"},
+					},
+					&cli.IntFlag{
+						Name:    "workers",
+						Aliases: []string{"w"},
+						Value:   runtime.NumCPU(),
+						Usage:   "Number of jobs rendered in parallel",
+						EnvVars: []string{"CASPER_WORKERS"},
+					},
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   ".",
+						Usage:   "Directory of the images",
+						EnvVars: []string{"CASPER_OUTPUT"},
+					},
+					&cli.StringFlag{
+						Name:    "bucket",
+						Usage:   "S3 bucket the images are uploaded to instead of the output directory",
+						EnvVars: []string{"CASPER_BUCKET"},
+					},
+					&cli.IntFlag{
+						Name:  "max-attempts",
+						Value: DefaultMaxAttempts,
+						Usage: "Attempts of a job until it fails",
+					},
+					&cli.DurationFlag{
+						Name:  "retry-delay",
+						Value: DefaultRetryDelay,
+						Usage: "Delay of the first retry, doubled with every attempt",
+					},
+					&cli.DurationFlag{
+						Name:  "poll-interval",
+						Value: DefaultPollInterval,
+						Usage: "Interval of polling the table if no notification arrives",
+					},
+					&cli.DurationFlag{
+						Name:  "lease",
+						Value: DefaultJobLease,
+						Usage: "Duration after which a running job of a crashed worker is rendered again, 0 disables it",
+					},
+					&cli.BoolFlag{
+						Name:  "exit-when-empty",
+						Usage: "Exit once no job is due instead of waiting for new jobs",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Int("max-attempts") < 1 {
+						return fmt.Errorf("max attempts have to be positive")
+					}
+					// a poll interval of 0 would claim jobs in a busy loop
+					if c.Duration("retry-delay") <= 0 || c.Duration("poll-interval") <= 0 {
+						return fmt.Errorf("retry delay and poll interval have to be positive")
+					}
+					if c.Duration("lease") < 0 {
+						return fmt.Errorf("lease must not be negative")
+					}
+					source := Source.(*PostgresSource)
+					worker := &Worker{
+						Queue:        NewPostgresQueue(source, c.String("table"), c.Duration("lease")),
+						Source:       Source,
+						Options:      Options,
+						Store:        &DirectoryStore{Dir: c.String("output")},
+						Prefix:       Prefix,
+						Workers:      c.Int("workers"),
+						MaxAttempts:  c.Int("max-attempts"),
+						RetryDelay:   c.Duration("retry-delay"),
+						PollInterval: c.Duration("poll-interval"),
+						// the lease is renewed three times per lease, so a delayed renewal does not expire it
+						RenewInterval: c.Duration("lease") / 3,
+						ExitWhenEmpty: c.Bool("exit-when-empty"),
+					}
+					if bucket := c.String("bucket"); bucket != "" {
+						sess, err := NewSession()
+						if err != nil {
+							return err
+						}
+						worker.Store = &S3Store{Client: s3.New(sess), Bucket: bucket}
+					}
+					// on interrupt the jobs being rendered are released for other workers
+					ctx, cancel := InterruptContext(c.Context)
+					defer cancel()
+					if channel := c.String("channel"); channel != "" && !worker.ExitWhenEmpty {
+						notify, err := ListenJobs(ctx, source.DataSource, channel)
+						if err != nil {
+							return err
+						}
+						worker.Notify = notify
+					}
+					err := worker.Run(ctx)
+					if errors.Is(err, context.Canceled) {
+						// stopping the worker is no failure
+						return nil
+					}
+					return err
+				},
+			},
+			{
+				Name:  "invoke",
+				Usage: "Invoke the lambda handler locally with an event from a json file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "event",
+						Aliases:  []string{"e"},
+						Usage:    "Path to the json event",
+						Required: true,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					return InvokeLocal(c.String("event"), Source, Options)
+				},
+			},
 		},
 		Action: func(c *cli.Context) error {
 			LOCAL, _ := strconv.ParseBool(os.Getenv("LOCAL"))
-			log.Printf("Processing Flight ID %d\n", FlightID)
 			// switch between lambda and local environment
 			if LOCAL == true {
-				PlotFlight(FlightID, CircleThickness, Prefix)
+				switch {
+				case DayID != 0:
+					log.Printf("Processing Competition Day ID %d\n", DayID)
+					ids, err := Source.CompetitionDay(c.Context, DayID)
+					if err != nil {
+						return err
+					}
+					return PlotFlights(c.Context, Source, ids, Options, fmt.Sprintf("%sDay_%d.png", Prefix, DayID))
+				case IDs != "":
+					log.Printf("Processing Flight IDs %s\n", IDs)
+					ids, err := ParseFlightIDs(IDs)
+					if err != nil {
+						return err
+					}
+					names := make([]string, len(ids))
+					for i, id := range ids {
+						names[i] = strconv.FormatUint(uint64(id), 10)
+					}
+					return PlotFlights(c.Context, Source, ids, Options, fmt.Sprintf("%sFlights_%s.png", Prefix, strings.Join(names, "_")))
+				}
+				log.Printf("Processing Flight ID %d\n", FlightID)
+				return PlotFlight(c.Context, Source, FlightID, Options, Prefix)
 			}
+			lambda.Start(NewLambdaHandler(Source, Options))
 			return nil
 		},
 	}
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		os.Exit(ExitCode(err))
 	}
 }
 
-// fetch line strings from db by ids
-func PlotFlight(FlightID uint, CircleThickness float64, Prefix string) error {
-	var line orb.LineString
-	row := GetRow(FlightID)
+// InterruptContext returns a context that is cancelled on SIGINT or SIGTERM
+func InterruptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			log.Println("Interrupted, waiting for the workers")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// PlotFlight renders the flight and saves the image to the working directory
+func PlotFlight(ctx context.Context, Source FlightSource, FlightID uint, Options RenderOptions, Prefix string) error {
+	return PlotFlights(ctx, Source, []uint{FlightID}, Options, fmt.Sprintf("%sFlight_%d.png", Prefix, FlightID))
+}
+
+// PlotFlights renders the flights into one image and saves it as FileName
+func PlotFlights(ctx context.Context, Source FlightSource, FlightIDs []uint, Options RenderOptions, FileName string) error {
+	rendering, err := RenderFlights(ctx, Source, FlightIDs, Options)
+	if err != nil {
+		return err
+	}
+	return SaveImage(rendering, FileName)
+}
 
-	// Array for postgres query
-	arr := pq.Float64Array{}
-	// parse to ST_AsBinary(line_wkt) and bbox to arr
-	err := row.Scan(wkb.Scanner(&line), &arr)
+// PlotFile renders the flights of the file into one image, the task is only drawn
+// with ShowTask and a task file takes precedence over the task declared by IGC files
+func PlotFile(ctx context.Context, FileName string, format string, Options RenderOptions, Output string) error {
+	log.Printf("Processing %s\n", FileName)
+	source, err := NewFileSource(FileName, format)
 	if err != nil {
-		panic(err)
-	}
-
-	// Cast postgres array to native go array
-	bbox := TransformBbox([]float64(arr))
-	ImageFlight := NewImage(bbox)
-	// Find Tiles including the zoom level
-	ImageFlight.FindRootTile()
-	// Determine tiles and download them
-	tiles, ZoomIncrease := TilesDownload(ImageFlight.RootTile.X, ImageFlight.RootTile.Y, ImageFlight.RootTile.Z)
-	DownloadTiles(tiles, ImageFlight.RootTile.Z+ZoomIncrease)
-	CreateImage(tiles, "Flight")
-
-	// Handle GeoJSON Linestring
-	feature := geojson.NewFeature(line)
-	// Convert to lineString (the syntax Geometry. is necessary due to the interface)
-	line = feature.Geometry.(orb.LineString)
-
-	// open image
-	im, err := gg.LoadJPG("images/Flight_merged.jpeg")
+		return err
+	}
+	rendering, err := RenderFlights(ctx, source, source.IDs(), Options)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	dc := gg.NewContextForImage(im)
-	longShift := float64(ImageFlight.RootTile.X)
-	latShift := float64(ImageFlight.RootTile.Y)
+	return SaveImage(rendering, Output)
+}
 
-	// Plot each point of the linestring onto the image
-	log.Println("Plotting flight")
-	for _, value := range line {
-		// Obtain the lat and lon values converted into pixels
-		lonPixel, latPixel := LatLontoXY(TileSize, value[1], value[0], float64(ImageFlight.RootTile.Z))
+// SaveImage encodes the image of the rendering as png
+func SaveImage(rendering *Rendering, FileName string) error {
+	log.Println("Saving Image")
+	fo, err := os.Create(FileName)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+	if err := png.Encode(fo, rendering.Image); err != nil {
+		return fmt.Errorf("%w: %s", ErrEncode, err)
+	}
+	return nil
+}
+
+// Rendering is the result of the pipeline
+type Rendering struct {
+	Image image.Image
+	// Degraded are the tiles that were replaced by the fallback
+	Degraded []*TileError
+}
 
-		// -TileSize*longShift is necessary in order to shift the origin of the pixels based on the images
-		// otherwise lonPixel and latPixel don't match with the canvas
-		dc.DrawCircle(lonPixel-TileSize*longShift, latPixel-TileSize*latShift, CircleThickness)
-		dc.Stroke()
-		dc.SetRGB(ColorRed, ColorGreen, ColorBlue)
-		dc.Fill()
+// RenderFlight fetches the flight from the source by id and returns the cropped image
+func RenderFlight(ctx context.Context, Source FlightSource, FlightID uint, Options RenderOptions) (*Rendering, error) {
+	return RenderFlights(ctx, Source, []uint{FlightID}, Options)
+}
+
+// RenderFlights fetches the flights and their task from the source and renders them into one image
+func RenderFlights(ctx context.Context, Source FlightSource, FlightIDs []uint, Options RenderOptions) (*Rendering, error) {
+	if len(FlightIDs) == 0 {
+		return nil, fmt.Errorf("%w: no flight ids", ErrFlightNotFound)
+	} else if len(FlightIDs) > MaxFlights {
+		return nil, fmt.Errorf("at most %d flights can be rendered into one image", MaxFlights)
+	}
+	flights := make([]*Flight, len(FlightIDs))
+	for i, FlightID := range FlightIDs {
+		flight, err := Source.Flight(ctx, FlightID)
+		if err != nil {
+			return nil, err
+		}
+		flights[i] = flight
 	}
 
-	// ----------------- In this section the image will be cropped -----------------
+	// the task is shared by all flights, so the task of the first flight is drawn
+	var task *Task
+	if Options.ShowTask {
+		task = Options.Task
+	}
+	if task == nil && Options.ShowTask {
+		var err error
+		if task, err = Source.Task(ctx, FlightIDs[0]); err != nil {
+			log.Printf("Task of Flight ID %d not found: %s\n", FlightIDs[0], err)
+		}
+	}
+	return Render(flights, task, Options)
+}
+
+// Render draws the flights and the task, which may be nil, onto one map of their union bbox,
+// multiple flights are distinguished by the FlightColors unless they are colored by an attribute
+func Render(flights []*Flight, task *Task, Options RenderOptions) (*Rendering, error) {
+	if len(flights) == 0 {
+		return nil, fmt.Errorf("%w: no flights", ErrEmptyGeometry)
+	} else if len(flights) > MaxFlights {
+		return nil, fmt.Errorf("at most %d flights can be rendered into one image", MaxFlights)
+	}
+	// the flights are copied, so that the flights of the caller are not modified
+	flights = append([]*Flight(nil), flights...)
+	for i, flight := range flights {
+		if len(flight.Track.Line) == 0 {
+			return nil, fmt.Errorf("%w: %d", ErrEmptyGeometry, flight.ID)
+		}
+		copied := *flight
+		// Flights crossing the antimeridian continue beyond ±180°, the bbox and
+		// the plotted line are therefore based on the same unwrapped longitudes
+		copied.Track.Line = UnwrapLine(flight.Track.Line)
+		if i > 0 {
+			copied.Track.Line = AlignLine(copied.Track.Line, flights[0].Track.Line[0][0])
+		}
+		flights[i] = &copied
+	}
+	bbox := FlightsBound(flights)
+	if task != nil {
+		task = task.Align(flights[0].Track.Line[0][0])
+		bound := task.Bound()
+		bbox = [4]float64{math.Min(bbox[0], bound.Min[0]), math.Min(bbox[1], bound.Min[1]), math.Max(bbox[2], bound.Max[0]), math.Max(bbox[3], bound.Max[1])}
+	}
+	name := fmt.Sprintf("Flight_%d", flights[0].ID)
+
+	// Determine zoom level and tiles and download them
+	source := Options.Source
+	grid, err := PlanTiles(bbox, source, Options.Width, Options.Height, Options.Padding, Options.DPI)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Using %dx%d tiles of zoom level %d\n", grid.Columns, grid.Rows, grid.Z)
+	images, degraded, err := FetchTiles(Options.Cache, source, grid.Tiles(), grid.Z, Options.Fallback)
+	if err != nil {
+		return nil, err
+	}
+	merged := grid.MergeTiles(images)
+	SaveDebugImage(Options.DebugDir, name+"_merged", merged)
+
+	dc := gg.NewContextForImage(merged)
+	scale := float64(grid.Section.Dx()) / float64(Options.Width)
+	if len(Options.Airspaces) > 0 {
+		DrawAirspaces(dc, grid, Options.Airspaces, AirspaceWidth*scale)
+	}
+
+	// Plot each linestring as one path, the merged tiles are resampled to the
+	// requested size afterwards, so the line width is scaled accordingly
+	log.Println("Plotting flights")
+	legend := func(dc *gg.Context) {}
+	if Options.ColorBy != "" {
+		legend = drawColoredFlights(dc, grid, flights, Options, scale)
+	} else if len(flights) == 1 {
+		DrawTrack(dc, grid, flights[0].Track.Line, Options.Line, scale)
+	} else {
+		style := Options.Line
+		labels := make([]string, len(flights))
+		for i, flight := range flights {
+			style.Color = FlightColors[i%len(FlightColors)]
+			DrawTrack(dc, grid, flight.Track.Line, style, scale)
+			labels[i] = flight.Label()
+		}
+		if Options.Legend {
+			legend = func(dc *gg.Context) { DrawFlightLegend(dc, labels, FlightColors) }
+		}
+	}
+
+	if task != nil {
+		DrawTask(dc, grid, task, Options.TaskColor, TaskWidth*scale)
+	}
+
+	SaveDebugImage(Options.DebugDir, name+"_painted", dc.Image())
+
+	// Crop the section with the flights and resample it to the requested size
 	log.Println("Cropping")
-	// Calculate BBOX in pixels
-	lonPixelFirst, latPixelFirst := LatLontoXY(TileSize, bbox[1], bbox[0], float64(ImageFlight.RootTile.Z))
-	lonPixelSecond, latPixelSecond := LatLontoXY(TileSize, bbox[3], bbox[2], float64(ImageFlight.RootTile.Z))
-
-	// Subtract shifting of tiles
-	lonPixelFirst -= TileSize * longShift
-	lonPixelSecond -= TileSize * longShift
-	latPixelFirst -= TileSize * latShift
-	latPixelSecond -= TileSize * latShift
-
-	// Determine the the min nad max vlaues with buffer included
-	minLon := math.Min(lonPixelFirst, lonPixelSecond) * (1 - BufferforCropping)
-	minLat := math.Min(latPixelFirst, latPixelSecond) * (1 - BufferforCropping)
-	maxLon := math.Max(lonPixelFirst, lonPixelSecond) * (1 + BufferforCropping)
-	maxLat := math.Max(latPixelFirst, latPixelSecond) * (1 + BufferforCropping)
-
-	// determin "Pixeldistance" in Lat and Lon direction
-	distanceX := math.Abs(maxLon - minLon)
-	distanceY := math.Abs(maxLat - minLat)
-	// Use greater distance for distance
-	maxdistance := int(MaxFloat(distanceX, distanceY))
-	// the minimum size of an Image
-	if maxdistance < ImageSize {
-		maxdistance = ImageSize
-	}
-	croppedImg, err := cutter.Crop(dc.Image(), cutter.Config{
-		Width:  maxdistance,
-		Height: maxdistance,
-		Anchor: image.Point{int(minLon), int(minLat)},
+	section := ShiftInside(grid.Section, dc.Image().Bounds())
+	croppedImg := CropImage(dc.Image(), section, Options.Width, Options.Height)
+	// markers and the legend are drawn after resampling so that they stay sharp
+	final := gg.NewContextForRGBA(croppedImg)
+	DrawMarkers(final, flights, Options.StartMarker, Options.LandingMarker, Options.AirportLabel, func(lon float64, lat float64) (float64, float64) {
+		x, y := grid.Pixel(lon, lat)
+		return (x - float64(section.Min.X)) * float64(Options.Width) / float64(section.Dx()),
+			(y - float64(section.Min.Y)) * float64(Options.Height) / float64(section.Dy())
 	})
-	log.Println("Saving Image")
-	fo, err := os.Create(fmt.Sprintf("%sFlight_%d.jpeg", Prefix, FlightID))
-	err = png.Encode(fo, croppedImg)
-	return nil
+	legend(final)
+	return &Rendering{final.Image(), degraded}, nil
+}
+
+// drawColoredFlights colors all flights by the attribute with a shared range and
+// returns the function drawing the legend
+func drawColoredFlights(dc *gg.Context, grid *TileGrid, flights []*Flight, Options RenderOptions, scale float64) func(*gg.Context) {
+	values := make([][]float64, len(flights))
+	var all []float64
+	for i, flight := range flights {
+		var err error
+		if values[i], err = SegmentValues(&flight.Track, Options.ColorBy); err != nil {
+			// flights without altitude or time are still rendered in the line color
+			log.Printf("Coloring Flight ID %d by %s not possible: %s\n", flight.ID, Options.ColorBy, err)
+		}
+		all = append(all, values[i]...)
+	}
+	if len(all) == 0 {
+		for _, flight := range flights {
+			DrawTrack(dc, grid, flight.Track.Line, Options.Line, scale)
+		}
+		return func(dc *gg.Context) {}
+	}
+	palette := Options.Palette
+	if palette == nil {
+		palette = Palettes[DefaultPalettes[Options.ColorBy]]
+	}
+	min, max := ValueRange(all, Options.ColorBy)
+	for i, flight := range flights {
+		if len(values[i]) == 0 {
+			DrawTrack(dc, grid, flight.Track.Line, Options.Line, scale)
+		} else {
+			DrawColoredTrack(dc, grid, &flight.Track, values[i], palette, min, max, Options.Line, scale)
+		}
+	}
+	if !Options.Legend {
+		return func(dc *gg.Context) {}
+	}
+	return func(dc *gg.Context) { DrawLegend(dc, palette, min, max, Options.ColorBy) }
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"golang.org/x/image/draw"
+)
+
+// Shapes of the markers, any other value is the path of an icon
+const (
+	MarkerNone     string = "none"
+	MarkerCircle   string = "circle"
+	MarkerSquare   string = "square"
+	MarkerTriangle string = "triangle"
+	MarkerFlag     string = "flag"
+)
+
+// DefaultMarkerSize is the size of the markers in pixels of the image
+const DefaultMarkerSize float64 = 12
+
+var (
+	StartColor   = color.NRGBA{0x2e, 0x7d, 0x32, 0xff}
+	LandingColor = color.NRGBA{0xc6, 0x28, 0x28, 0xff}
+)
+
+// Marker is drawn at the first or last fix of a flight, either as shape or as icon
+type Marker struct {
+	Shape string
+	// Icon is scaled to the size and centered on the fix
+	Icon  image.Image
+	Size  float64
+	Color color.NRGBA
+}
+
+// ParseMarker returns the marker of the shape or loads the icon from the file,
+// none returns nil
+func ParseMarker(value string, size float64, c color.NRGBA) (*Marker, error) {
+	switch strings.ToLower(value) {
+	case "", MarkerNone:
+		return nil, nil
+	case MarkerCircle, MarkerSquare, MarkerTriangle, MarkerFlag:
+		return &Marker{Shape: strings.ToLower(value), Size: size, Color: c}, nil
+	}
+	file, err := os.Open(value)
+	if err != nil {
+		return nil, fmt.Errorf("marker is neither a shape (%s, %s, %s, %s) nor an icon: %w", MarkerCircle, MarkerSquare, MarkerTriangle, MarkerFlag, err)
+	}
+	defer file.Close()
+	icon, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid icon %s: %w", value, err)
+	}
+	return &Marker{Icon: icon, Size: size, Color: c}, nil
+}
+
+// ValidMarkerShape checks the shape of a marker, icons are not allowed
+func ValidMarkerShape(value string) bool {
+	switch strings.ToLower(value) {
+	case MarkerNone, MarkerCircle, MarkerSquare, MarkerTriangle, MarkerFlag:
+		return true
+	}
+	return false
+}
+
+// Draw draws the marker centered on x, y, the flag stands on it
+func (m *Marker) Draw(dc *gg.Context, x float64, y float64) {
+	dc.Push()
+	defer dc.Pop()
+	r := m.Size / 2
+	if m.Icon != nil {
+		bounds := m.Icon.Bounds()
+		// keep the aspect ratio of the icon, the larger side is the size
+		f := m.Size / math.Max(float64(bounds.Dx()), float64(bounds.Dy()))
+		w, h := int(math.Round(float64(bounds.Dx())*f)), int(math.Round(float64(bounds.Dy())*f))
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), m.Icon, bounds, draw.Over, nil)
+		dc.DrawImageAnchored(scaled, int(math.Round(x)), int(math.Round(y)), 0.5, 0.5)
+		return
+	}
+	switch m.Shape {
+	case MarkerCircle:
+		dc.DrawCircle(x, y, r)
+	case MarkerSquare:
+		dc.DrawRectangle(x-r, y-r, m.Size, m.Size)
+	case MarkerTriangle:
+		dc.DrawRegularPolygon(3, x, y, r*1.2, 0)
+	case MarkerFlag:
+		// pole from the fix upwards and the flag at its top
+		dc.SetRGB(0.2, 0.2, 0.2)
+		dc.SetLineWidth(math.Max(1, m.Size/8))
+		dc.DrawLine(x, y, x, y-2*m.Size)
+		dc.Stroke()
+		dc.MoveTo(x, y-2*m.Size)
+		dc.LineTo(x+m.Size, y-1.5*m.Size)
+		dc.LineTo(x, y-m.Size)
+		dc.ClosePath()
+	}
+	dc.SetColor(m.Color)
+	dc.FillPreserve()
+	// a white outline keeps the marker visible on any map
+	dc.SetRGB(1, 1, 1)
+	dc.SetLineWidth(math.Max(1, m.Size/8))
+	dc.Stroke()
+}
+
+// DrawMarkers draws the start and landing markers of all flights and labels the
+// takeoff airports if AirportLabel is set, project maps a point to the pixel of the image
+func DrawMarkers(dc *gg.Context, flights []*Flight, start *Marker, landing *Marker, AirportLabel bool, project func(lon float64, lat float64) (float64, float64)) {
+	labeled := make(map[string]bool)
+	for _, flight := range flights {
+		line := flight.Track.Line
+		if landing != nil {
+			x, y := project(line[len(line)-1][0], line[len(line)-1][1])
+			landing.Draw(dc, x, y)
+		}
+		x, y := project(line[0][0], line[0][1])
+		if start != nil {
+			start.Draw(dc, x, y)
+		}
+		if AirportLabel && flight.Airport != "" && !labeled[flight.Airport] {
+			offset := DefaultMarkerSize / 2
+			if start != nil {
+				offset = start.Size / 2
+			}
+			DrawLabel(dc, flight.Airport, x+offset+4, y)
+			labeled[flight.Airport] = true
+		}
+	}
+}
+
+// DrawLabel draws the text with a white outline next to x, y
+func DrawLabel(dc *gg.Context, text string, x float64, y float64) {
+	dc.Push()
+	defer dc.Pop()
+	dc.SetRGB(1, 1, 1)
+	for dy := -1.0; dy <= 1; dy++ {
+		for dx := -1.0; dx <= 1; dx++ {
+			dc.DrawStringAnchored(text, x+dx, y+dy, 0, 0.5)
+		}
+	}
+	dc.SetRGB(0.1, 0.1, 0.1)
+	dc.DrawStringAnchored(text, x, y, 0, 0.5)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+)
+
+func TestParseMarker(t *testing.T) {
+	if marker, err := ParseMarker("none", 10, StartColor); marker != nil || err != nil {
+		t.Errorf("None should disable the marker: %v %v", marker, err)
+	}
+	if marker, err := ParseMarker("Flag", 10, StartColor); err != nil || marker.Shape != MarkerFlag {
+		t.Errorf("Shape is not parsed: %v %v", marker, err)
+	}
+	if _, err := ParseMarker("hexagon", 10, StartColor); err == nil {
+		t.Error("Unknown shape should fail")
+	}
+
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := os.Create(filepath.Join(dir, "icon.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	icon := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	if err := png.Encode(file, icon); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if marker, err := ParseMarker(file.Name(), 10, StartColor); err != nil || marker.Icon == nil {
+		t.Errorf("Icon is not loaded: %v", err)
+	}
+	if ValidMarkerShape(file.Name()) {
+		t.Error("Icons must not be valid shapes")
+	}
+}
+
+func TestDrawMarkers(t *testing.T) {
+	icon := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			icon.Set(x, y, color.NRGBA{0, 0, 0xff, 0xff})
+		}
+	}
+	start := &Marker{Shape: MarkerSquare, Size: 10, Color: StartColor}
+	landing := &Marker{Icon: icon, Size: 10}
+	flights := []*Flight{
+		{Airport: "Eggersdorf", Track: Track{Line: orb.LineString{{20, 20}, {80, 80}}}},
+		{Airport: "Eggersdorf", Track: Track{Line: orb.LineString{{20, 60}, {60, 20}}}},
+	}
+	project := func(lon float64, lat float64) (float64, float64) { return lon, lat }
+	dc := gg.NewContext(100, 100)
+	DrawMarkers(dc, flights, start, landing, true, project)
+
+	for _, c := range []struct {
+		name     string
+		x, y     int
+		expected color.Color
+	}{
+		{"start", 20, 20, StartColor},
+		{"landing icon", 80, 80, color.NRGBA{0, 0, 0xff, 0xff}},
+		{"second start", 20, 60, StartColor},
+	} {
+		if current := color.NRGBAModel.Convert(dc.Image().At(c.x, c.y)); current != c.expected {
+			t.Errorf("%s: color is %v, expected %v", c.name, current, c.expected)
+		}
+	}
+	// the airport is labeled once right of the first start, left of the landing icon of the second flight
+	labeled := func(dc *gg.Context, y int) bool {
+		for x := 30; x < 50; x++ {
+			for dy := -6; dy <= 6; dy++ {
+				if _, _, _, a := dc.Image().At(x, y+dy).RGBA(); a != 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !labeled(dc, 20) {
+		t.Error("Airport is not labeled")
+	}
+	if labeled(dc, 60) {
+		t.Error("Airport is labeled twice")
+	}
+
+	// the same flights without the airport label
+	unlabeled := gg.NewContext(100, 100)
+	DrawMarkers(unlabeled, flights, start, landing, false, project)
+	if labeled(unlabeled, 20) {
+		t.Error("Airport is labeled without AirportLabel")
+	}
+}
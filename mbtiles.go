@@ -0,0 +1,82 @@
+//go:build mbtiles
+// +build mbtiles
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	// registers the sqlite3 driver for mbtiles, the driver requires cgo
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// MBTiles is a SQLite database with the tiles in the TMS numbering,
+// see https://github.com/mapbox/mbtiles-spec
+type MBTiles struct {
+	DB   *sql.DB
+	info ArchiveInfo
+}
+
+// OpenMBTiles opens the database read only and reads its metadata
+func OpenMBTiles(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	archive := &MBTiles{DB: db}
+	rows, err := db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("invalid mbtiles %s: %w", path, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			db.Close()
+			return nil, err
+		}
+		switch name {
+		case "format":
+			archive.info.Format = value
+		case "maxzoom":
+			zoom, err := strconv.ParseInt(value, 10, 16)
+			if err == nil {
+				archive.info.MaxZoom = int16(zoom)
+			}
+		case "attribution":
+			archive.info.Attribution = value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if archive.info.Format == "jpg" {
+		archive.info.Format = "jpeg"
+	}
+	if archive.info.MaxZoom == 0 {
+		// the max zoom is optional in the metadata
+		var zoom sql.NullInt64
+		if err := db.QueryRow("SELECT MAX(zoom_level) FROM tiles").Scan(&zoom); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("invalid mbtiles %s: %w", path, err)
+		}
+		archive.info.MaxZoom = int16(zoom.Int64)
+	}
+	return archive, nil
+}
+
+func (a *MBTiles) Info() ArchiveInfo { return a.info }
+
+func (a *MBTiles) ReadTile(z int16, x int16, y int16) ([]byte, error) {
+	var content []byte
+	row := int(1)<<uint(z) - 1 - int(y)
+	err := a.DB.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?", z, x, row).Scan(&content)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("tile %d/%d/%d is not in the archive", z, x, y)
+	}
+	return content, err
+}
@@ -0,0 +1,12 @@
+//go:build !mbtiles
+// +build !mbtiles
+
+package main
+
+import "fmt"
+
+// OpenMBTiles fails unless casper is built with -tags mbtiles, the SQLite driver
+// requires cgo and is therefore not part of the default build
+func OpenMBTiles(path string) (TileArchive, error) {
+	return nil, fmt.Errorf("%s: mbtiles require casper to be built with -tags mbtiles", path)
+}
@@ -0,0 +1,37 @@
+//go:build mbtiles
+// +build mbtiles
+
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// WriteMBTiles creates the archive with the tiles given in the XYZ numbering
+func WriteMBTiles(t *testing.T, path string, format string, tiles map[[3]int16][]byte) {
+	db, err := sql.Open("sqlite3", path)
+	CheckError(t, err)
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE metadata (name text, value text);
+		CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob);`)
+	CheckError(t, err)
+	_, err = db.Exec("INSERT INTO metadata (name, value) VALUES ('format', ?), ('attribution', 'Test')", format)
+	CheckError(t, err)
+	for tile, content := range tiles {
+		row := int(1)<<uint(tile[0]) - 1 - int(tile[2])
+		_, err = db.Exec("INSERT INTO tiles VALUES (?, ?, ?, ?)", tile[0], tile[1], row, content)
+		CheckError(t, err)
+	}
+}
+
+func TestMBTiles(t *testing.T) {
+	dir := t.TempDir()
+	tiles, colors := ArchiveTestTiles(t)
+	WriteMBTiles(t, filepath.Join(dir, "test.mbtiles"), "png", tiles)
+	CheckArchiveSource(t, filepath.Join(dir, "test.mbtiles"), colors)
+
+	WriteMBTiles(t, filepath.Join(dir, "vector.mbtiles"), "pbf", map[[3]int16][]byte{{5, 8, 5}: NewTestVectorTile(t)})
+	CheckVectorArchive(t, filepath.Join(dir, "vector.mbtiles"))
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// MaxPadding keeps the flight at least a fifth of the width or height of the image,
+// a larger padding would shrink it to a few pixels
+const MaxPadding float64 = 2
+
+// RenderOptions configures how a flight image is rendered
+type RenderOptions struct {
+	Line LineStyle
+	// ColorBy colors the track per segment by altitude, speed or vario, empty draws it in the line color
+	ColorBy string
+	// Palette of the colored track, nil uses the default palette of the attribute
+	Palette Palette
+	// Legend draws the range of the palette onto the image
+	Legend bool
+	// ShowTask draws the declared task of the flight from the db
+	ShowTask bool
+	// Task is drawn instead of the declared task if it is set
+	Task      *Task
+	TaskColor color.NRGBA
+	// StartMarker and LandingMarker are drawn at the first and last fix, nil disables them
+	StartMarker   *Marker
+	LandingMarker *Marker
+	MarkerSize    float64
+	// AirportLabel labels the start marker with the takeoff airport
+	AirportLabel bool
+	// Airspaces are drawn beneath the track
+	Airspaces []*Airspace
+	// Width and Height of the image in pixels
+	Width  int
+	Height int
+	// Padding around the flight relative to its extent, e.g. 0.1 means 10 %
+	Padding float64
+	// DPI of the image, a higher dpi renders the map with more detailed tiles
+	DPI    float64
+	Source TileSource
+	Cache  *TileCache
+	// Fallback for tiles that could not be fetched: none, fill or parent
+	Fallback string
+	// DebugDir receives the intermediate images of the pipeline if it is set
+	DebugDir string
+}
+
+// RenderFlags are the cli flags shared by all commands to configure the rendering
+func RenderFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.Float64Flag{
+			Name:    "thickness",
+			Value:   2.0,
+			Aliases: []string{"th"},
+			Usage:   "Thickness of the line string in pixels",
+		},
+		&cli.StringFlag{
+			Name:    "line-color",
+			Value:   "#2d55a6",
+			Usage:   "Color of the line string",
+			EnvVars: []string{"CASPER_LINE_COLOR"},
+		},
+		&cli.StringFlag{
+			Name:    "line-join",
+			Value:   "round",
+			Usage:   "Join of the line string segments: round or bevel",
+			EnvVars: []string{"CASPER_LINE_JOIN"},
+		},
+		&cli.StringFlag{
+			Name:    "line-cap",
+			Value:   "round",
+			Usage:   "Cap of the line string ends: round, butt or square",
+			EnvVars: []string{"CASPER_LINE_CAP"},
+		},
+		&cli.Float64Flag{
+			Name:    "halo",
+			Value:   0,
+			Usage:   "Width of the outline on each side of the line string in pixels, 0 disables it",
+			EnvVars: []string{"CASPER_HALO"},
+		},
+		&cli.StringFlag{
+			Name:    "halo-color",
+			Value:   "#ffffff",
+			Usage:   "Color of the outline",
+			EnvVars: []string{"CASPER_HALO_COLOR"},
+		},
+		&cli.StringFlag{
+			Name:    "color-by",
+			Usage:   "Color the line string by altitude, speed or vario",
+			EnvVars: []string{"CASPER_COLOR_BY"},
+		},
+		&cli.StringFlag{
+			Name:    "palette",
+			Usage:   fmt.Sprintf("Palette of the colored line string (%s) or comma separated colors", strings.Join(PaletteNames(), ", ")),
+			EnvVars: []string{"CASPER_PALETTE"},
+		},
+		&cli.BoolFlag{
+			Name:    "legend",
+			Usage:   "Draw a legend of the palette",
+			EnvVars: []string{"CASPER_LEGEND"},
+		},
+		&cli.BoolFlag{
+			Name:    "task",
+			Usage:   "Draw the declared task of the flight",
+			EnvVars: []string{"CASPER_TASK"},
+		},
+		&cli.StringFlag{
+			Name:    "task-file",
+			Usage:   "Json file with a task that is drawn instead of the declared task",
+			EnvVars: []string{"CASPER_TASK_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "task-color",
+			Value:   "#c2185b",
+			Usage:   "Color of the task",
+			EnvVars: []string{"CASPER_TASK_COLOR"},
+		},
+		&cli.StringFlag{
+			Name:    "start-marker",
+			Value:   MarkerNone,
+			Usage:   "Marker at the first fix: none, circle, square, triangle, flag or the path of an icon",
+			EnvVars: []string{"CASPER_START_MARKER"},
+		},
+		&cli.StringFlag{
+			Name:    "landing-marker",
+			Value:   MarkerNone,
+			Usage:   "Marker at the last fix: none, circle, square, triangle, flag or the path of an icon",
+			EnvVars: []string{"CASPER_LANDING_MARKER"},
+		},
+		&cli.Float64Flag{
+			Name:    "marker-size",
+			Value:   DefaultMarkerSize,
+			Usage:   "Size of the markers in pixels",
+			EnvVars: []string{"CASPER_MARKER_SIZE"},
+		},
+		&cli.BoolFlag{
+			Name:    "airport-label",
+			Usage:   "Label the start with the name of the takeoff airport",
+			EnvVars: []string{"CASPER_AIRPORT_LABEL"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "airspace",
+			Usage:   "OpenAir file with airspaces drawn beneath the line string, can be repeated",
+			EnvVars: []string{"CASPER_AIRSPACE"},
+		},
+		&cli.IntFlag{
+			Name:    "width",
+			Value:   ImageSize,
+			Usage:   "Width of the image in pixels",
+			EnvVars: []string{"CASPER_WIDTH"},
+		},
+		&cli.IntFlag{
+			Name:    "height",
+			Value:   ImageSize,
+			Usage:   "Height of the image in pixels",
+			EnvVars: []string{"CASPER_HEIGHT"},
+		},
+		&cli.Float64Flag{
+			Name:    "padding",
+			Value:   BufferforCropping,
+			Usage:   "Padding around the flight relative to its extent",
+			EnvVars: []string{"CASPER_PADDING"},
+		},
+		&cli.Float64Flag{
+			Name:    "dpi",
+			Value:   DefaultDPI,
+			Usage:   "Resolution of the image, e.g. 192 renders the map with twice as detailed tiles",
+			EnvVars: []string{"CASPER_DPI"},
+		},
+		&cli.StringFlag{
+			Name:    "tiles",
+			Value:   DefaultTileSource,
+			Usage:   fmt.Sprintf("Tile source of the map (%s) or the path of a MBTiles or PMTiles archive", strings.Join(TileSourceNames(), ", ")),
+			EnvVars: []string{"CASPER_TILES"},
+		},
+		&cli.StringFlag{
+			Name:    "tile-config",
+			Usage:   "Json file defining a custom xyz, tms or wmts tile source, overrides tiles",
+			EnvVars: []string{"CASPER_TILE_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "cache-dir",
+			Value:   DefaultCacheDir,
+			Usage:   "Directory of the tile cache",
+			EnvVars: []string{"CASPER_CACHE_DIR"},
+		},
+		&cli.DurationFlag{
+			Name:    "cache-ttl",
+			Value:   DefaultCacheTTL,
+			Usage:   "Duration after which cached tiles are revalidated",
+			EnvVars: []string{"CASPER_CACHE_TTL"},
+		},
+		&cli.Int64Flag{
+			Name:    "cache-size",
+			Value:   DefaultCacheSize >> 20,
+			Usage:   "Size cap of the tile cache in MB, 0 disables the cap",
+			EnvVars: []string{"CASPER_CACHE_SIZE"},
+		},
+		&cli.StringFlag{
+			Name:    "fallback",
+			Value:   FallbackParent,
+			Usage:   "Replacement of tiles that could not be fetched: none (fail), fill (solid color) or parent (upscaled tile of a lower zoom level)",
+			EnvVars: []string{"CASPER_FALLBACK"},
+		},
+		&cli.StringFlag{
+			Name:    "debug-dir",
+			Usage:   "Directory for the intermediate images of the pipeline",
+			EnvVars: []string{"CASPER_DEBUG_DIR"},
+		},
+	}
+}
+
+// ParseRenderOptions reads and validates the RenderFlags
+func ParseRenderOptions(c *cli.Context) (Options RenderOptions, err error) {
+	if tiles := c.String("tiles"); IsTileArchive(tiles) && c.String("tile-config") == "" {
+		// archives are only opened from the cli, requests of the server select built-in sources
+		config := TileSourceConfig{Name: strings.TrimSuffix(filepath.Base(tiles), filepath.Ext(tiles)), URL: tiles}
+		Options.Source, err = config.Source()
+	} else {
+		Options.Source, err = LoadTileSource(tiles, c.String("tile-config"))
+	}
+	if err != nil {
+		return
+	}
+	Options.Cache = NewTileCache(c.String("cache-dir"), c.Duration("cache-ttl"), c.Int64("cache-size")<<20)
+	Options.DebugDir = c.String("debug-dir")
+	switch Options.Fallback = c.String("fallback"); Options.Fallback {
+	case FallbackNone, FallbackFill, FallbackParent:
+	default:
+		return Options, fmt.Errorf("unknown fallback %q", Options.Fallback)
+	}
+
+	Options.Width, Options.Height = c.Int("width"), c.Int("height")
+	if !ValidSize(Options.Width) || !ValidSize(Options.Height) {
+		return Options, fmt.Errorf("width and height have to be between 1 and %d pixels", MaxImageSize)
+	}
+	Options.Padding = c.Float64("padding")
+	if Options.Padding < 0 || Options.Padding > MaxPadding {
+		return Options, fmt.Errorf("padding has to be between 0 and %.0f", MaxPadding)
+	}
+	Options.DPI = c.Float64("dpi")
+	if Options.DPI <= 0 || Options.DPI > MaxDPI {
+		return Options, fmt.Errorf("dpi has to be between 0 and %.0f", MaxDPI)
+	}
+	if err = CheckPixels(Options.Width, Options.Height, Options.DPI); err != nil {
+		return
+	}
+
+	Options.Line.Width = c.Float64("thickness")
+	Options.Line.HaloWidth = c.Float64("halo")
+	if Options.Line.Width <= 0 || Options.Line.HaloWidth < 0 {
+		return Options, fmt.Errorf("thickness has to be positive")
+	}
+	if Options.Line.Join, err = ParseLineJoin(c.String("line-join")); err != nil {
+		return
+	}
+	if Options.Line.Cap, err = ParseLineCap(c.String("line-cap")); err != nil {
+		return
+	}
+	if Options.Line.Color, err = ParseHexColor(c.String("line-color")); err != nil {
+		return
+	}
+	if Options.Line.HaloColor, err = ParseHexColor(c.String("halo-color")); err != nil {
+		return
+	}
+	if Options.ColorBy = c.String("color-by"); !ValidColorBy(Options.ColorBy) {
+		return Options, fmt.Errorf("unknown attribute %q, available: %s, %s, %s", Options.ColorBy, ColorByAltitude, ColorBySpeed, ColorByVario)
+	}
+	if value := c.String("palette"); value != "" {
+		if Options.Palette, err = ParsePalette(value); err != nil {
+			return
+		}
+	}
+	Options.Legend = c.Bool("legend")
+
+	Options.ShowTask = c.Bool("task")
+	if file := c.String("task-file"); file != "" {
+		if Options.Task, err = LoadTask(file); err != nil {
+			return
+		}
+		Options.ShowTask = true
+	}
+	taskColor, err := ParseHexColor(c.String("task-color"))
+	if err != nil {
+		return
+	}
+	Options.TaskColor = taskColor.(color.NRGBA)
+
+	Options.MarkerSize = c.Float64("marker-size")
+	if Options.MarkerSize <= 0 {
+		return Options, fmt.Errorf("marker size has to be positive")
+	}
+	if Options.StartMarker, err = ParseMarker(c.String("start-marker"), Options.MarkerSize, StartColor); err != nil {
+		return
+	}
+	if Options.LandingMarker, err = ParseMarker(c.String("landing-marker"), Options.MarkerSize, LandingColor); err != nil {
+		return
+	}
+	Options.AirportLabel = c.Bool("airport-label")
+
+	for _, file := range c.StringSlice("airspace") {
+		airspaces, err := LoadAirspaces(file)
+		if err != nil {
+			return Options, err
+		}
+		Options.Airspaces = append(Options.Airspaces, airspaces...)
+	}
+	return
+}
+
+// ValidSize checks the width or height of an image
+func ValidSize(size int) bool {
+	return size > 0 && size <= MaxImageSize
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+// ParseTestOptions parses the options from the cli arguments
+func ParseTestOptions(args ...string) (Options RenderOptions, err error) {
+	app := &cli.App{
+		Flags: RenderFlags(),
+		Action: func(c *cli.Context) (err error) {
+			Options, err = ParseRenderOptions(c)
+			return
+		},
+	}
+	err = app.Run(append([]string{"casper"}, args...))
+	return
+}
+
+func TestParseRenderOptions(t *testing.T) {
+	options, err := ParseTestOptions("--color-by", "altitude", "--palette", "#000000,#ffffff")
+	CheckError(t, err)
+	if len(options.Palette) != 2 {
+		t.Errorf("Palette is not parsed: %v", options.Palette)
+	}
+	if _, err := ParseTestOptions("--palette", "#000000,invalid"); err == nil {
+		t.Error("Invalid palette is accepted")
+	}
+	for _, padding := range []string{"-0.1", "2.5"} {
+		if _, err := ParseTestOptions("--padding", padding); err == nil {
+			t.Errorf("Padding %s is accepted", padding)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Constants of the PMTiles version 3 specification, see https://github.com/protomaps/PMTiles
+const (
+	PMTilesHeaderSize int = 127
+	// PMTilesMaxDepth limits the nesting of leaf directories
+	PMTilesMaxDepth int = 4
+
+	pmCompressionUnknown uint8 = 0
+	pmCompressionNone    uint8 = 1
+	pmCompressionGzip    uint8 = 2
+)
+
+// pmTileTypes maps the tile type of the header to the format of the tiles
+var pmTileTypes = map[uint8]string{1: "pbf", 2: "png", 3: "jpeg", 4: "webp"}
+
+// PMTiles is a single file archive whose tiles are addressed by directories of
+// tile ids on a Hilbert curve
+type PMTiles struct {
+	file   *os.File
+	header pmHeader
+	info   ArchiveInfo
+	root   []pmEntry
+	// leaves caches the decoded leaf directories by their offset
+	leaves map[uint64][]pmEntry
+	mu     sync.Mutex
+}
+
+type pmHeader struct {
+	RootOffset, RootLength         uint64
+	MetadataOffset, MetadataLength uint64
+	LeafOffset, LeafLength         uint64
+	DataOffset, DataLength         uint64
+	InternalCompression            uint8
+	TileCompression                uint8
+	TileType                       uint8
+	MinZoom, MaxZoom               uint8
+}
+
+// pmEntry either points to the data of RunLength tiles with consecutive ids or,
+// with a RunLength of 0, to a leaf directory
+type pmEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// OpenPMTiles opens the archive and reads its header and root directory
+func OpenPMTiles(path string) (*PMTiles, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	archive := &PMTiles{file: file, leaves: make(map[uint64][]pmEntry)}
+	if err := archive.open(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid pmtiles %s: %w", path, err)
+	}
+	return archive, nil
+}
+
+func (a *PMTiles) open() error {
+	buf := make([]byte, PMTilesHeaderSize)
+	if _, err := a.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if string(buf[:7]) != "PMTiles" {
+		return errors.New("missing magic number")
+	}
+	if buf[7] != 3 {
+		return fmt.Errorf("unsupported version %d", buf[7])
+	}
+	u64 := func(offset int) uint64 { return binary.LittleEndian.Uint64(buf[offset : offset+8]) }
+	a.header = pmHeader{
+		RootOffset: u64(8), RootLength: u64(16),
+		MetadataOffset: u64(24), MetadataLength: u64(32),
+		LeafOffset: u64(40), LeafLength: u64(48),
+		DataOffset: u64(56), DataLength: u64(64),
+		InternalCompression: buf[97],
+		TileCompression:     buf[98],
+		TileType:            buf[99],
+		MinZoom:             buf[100],
+		MaxZoom:             buf[101],
+	}
+	switch a.header.TileCompression {
+	case pmCompressionUnknown, pmCompressionNone, pmCompressionGzip:
+	default:
+		return fmt.Errorf("unsupported tile compression %d", a.header.TileCompression)
+	}
+	a.info = ArchiveInfo{Format: pmTileTypes[a.header.TileType], MaxZoom: int16(a.header.MaxZoom)}
+	var err error
+	a.root, err = a.directory(a.header.RootOffset, a.header.RootLength)
+	return err
+}
+
+func (a *PMTiles) Info() ArchiveInfo { return a.info }
+
+// ReadTile searches the tile in the root directory and the leaf directories
+func (a *PMTiles) ReadTile(z int16, x int16, y int16) ([]byte, error) {
+	id := PMTileID(z, x, y)
+	entries := a.root
+	for depth := 0; depth < PMTilesMaxDepth; depth++ {
+		entry, ok := findEntry(entries, id)
+		if !ok {
+			break
+		}
+		if entry.RunLength > 0 {
+			content, err := a.read(a.header.DataOffset+entry.Offset, uint64(entry.Length))
+			if err != nil {
+				return nil, err
+			}
+			if a.header.TileCompression == pmCompressionGzip {
+				return gunzip(content)
+			}
+			return content, nil
+		}
+		var err error
+		if entries, err = a.leaf(entry.Offset, uint64(entry.Length)); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("tile %d/%d/%d is not in the archive", z, x, y)
+}
+
+// leaf returns the cached leaf directory
+func (a *PMTiles) leaf(offset uint64, length uint64) ([]pmEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if entries, ok := a.leaves[offset]; ok {
+		return entries, nil
+	}
+	entries, err := a.directory(a.header.LeafOffset+offset, length)
+	if err != nil {
+		return nil, err
+	}
+	a.leaves[offset] = entries
+	return entries, nil
+}
+
+// directory decodes the directory at the absolute offset, the entries are stored
+// column wise as varints: the deltas of the tile ids, the run lengths, the lengths
+// and the offsets, an offset of 0 continues the previous entry
+func (a *PMTiles) directory(offset uint64, length uint64) ([]pmEntry, error) {
+	content, err := a.read(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	switch a.header.InternalCompression {
+	case pmCompressionGzip:
+		if content, err = gunzip(content); err != nil {
+			return nil, err
+		}
+	case pmCompressionNone, pmCompressionUnknown:
+	default:
+		return nil, fmt.Errorf("unsupported directory compression %d", a.header.InternalCompression)
+	}
+	r := bytes.NewReader(content)
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(content)) {
+		return nil, errors.New("invalid directory size")
+	}
+	entries := make([]pmEntry, n)
+	var id uint64
+	for i := range entries {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		id += delta
+		entries[i].TileID = id
+	}
+	for i := range entries {
+		value, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].RunLength = uint32(value)
+	}
+	for i := range entries {
+		value, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Length = uint32(value)
+	}
+	for i := range entries {
+		value, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if value == 0 && i > 0 {
+			entries[i].Offset = entries[i-1].Offset + uint64(entries[i-1].Length)
+		} else {
+			entries[i].Offset = value - 1
+		}
+	}
+	return entries, nil
+}
+
+func (a *PMTiles) read(offset uint64, length uint64) ([]byte, error) {
+	buf := make([]byte, length)
+	if _, err := a.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// findEntry returns the entry with the highest tile id that is not greater than the id,
+// tiles have to be within the run of the entry while leaf directories cover all ids
+// up to the next entry
+func findEntry(entries []pmEntry, id uint64) (pmEntry, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].TileID > id }) - 1
+	if i < 0 {
+		return pmEntry{}, false
+	}
+	entry := entries[i]
+	if entry.RunLength > 0 && id >= entry.TileID+uint64(entry.RunLength) {
+		return pmEntry{}, false
+	}
+	return entry, true
+}
+
+// PMTileID returns the id of the tile, the ids of a zoom level follow those of the
+// lower zoom levels and are ordered along a Hilbert curve
+func PMTileID(z int16, x int16, y int16) uint64 {
+	// number of tiles on all lower zoom levels
+	id := (uint64(1)<<(2*uint(z)) - 1) / 3
+	n := int64(1) << uint(z)
+	tx, ty := int64(x), int64(y)
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry int64
+		if tx&s > 0 {
+			rx = 1
+		}
+		if ty&s > 0 {
+			ry = 1
+		}
+		id += uint64(s * s * ((3 * rx) ^ ry))
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				tx, ty = n-1-tx, n-1-ty
+			}
+			tx, ty = ty, tx
+		}
+	}
+	return id
+}
+
+func gunzip(content []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
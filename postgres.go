@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// DefaultQueryTimeout limits the duration of a single query
+	DefaultQueryTimeout time.Duration = 10 * time.Second
+	// DefaultMaxConnections is the size of the connection pool
+	DefaultMaxConnections int = 10
+)
+
+// PostgresSchema maps the flights to the tables and columns of the db, the default
+// is the schema of weglide
+type PostgresSchema struct {
+	// Table of the flights, optionally qualified by the schema like public.flight
+	Table      string `json:"table"`
+	IDColumn   string `json:"id_column"`
+	LineColumn string `json:"line_column"`
+	// DayColumn references the competition day of a flight, empty disables competition days
+	DayColumn string `json:"day_column"`
+	// MetadataQuery returns the name of the pilot and of the takeoff airport of the flight $1,
+	// empty disables the metadata
+	MetadataQuery string `json:"metadata_query"`
+	// TaskQuery returns the task of the flight $1 as json, empty disables tasks
+	TaskQuery string `json:"task_query"`
+}
+
+// DefaultPostgresSchema is the schema of the weglide db
+var DefaultPostgresSchema = PostgresSchema{
+	Table:      "flight",
+	IDColumn:   "id",
+	LineColumn: "line_wkt",
+	DayColumn:  "competition_day_id",
+	MetadataQuery: `SELECT u.name, a.name FROM flight f
+		LEFT JOIN "user" u ON u.id = f.user_id
+		LEFT JOIN airport a ON a.id = f.takeoff_airport_id
+		WHERE f.id = $1`,
+	TaskQuery: "SELECT t.data FROM flight f JOIN task t ON t.id = f.task_id WHERE f.id = $1",
+}
+
+// LoadPostgresSchema reads the schema from a json file, missing values are taken
+// from the default schema. The default day column and queries only fit the weglide
+// tables, so they are disabled unless declared if the table or its columns differ.
+func LoadPostgresSchema(FileName string) (PostgresSchema, error) {
+	schema := DefaultPostgresSchema
+	content, err := ioutil.ReadFile(FileName)
+	if err != nil {
+		return schema, err
+	}
+	if err := json.Unmarshal(content, &schema); err != nil {
+		return schema, fmt.Errorf("invalid schema %s: %w", FileName, err)
+	}
+	if schema.Table != DefaultPostgresSchema.Table || schema.IDColumn != DefaultPostgresSchema.IDColumn ||
+		schema.LineColumn != DefaultPostgresSchema.LineColumn {
+		schema = PostgresSchema{Table: schema.Table, IDColumn: schema.IDColumn, LineColumn: schema.LineColumn}
+		// the content was decoded before, so only the declared values are set again
+		json.Unmarshal(content, &schema)
+	}
+	if schema.Table == "" || schema.IDColumn == "" || schema.LineColumn == "" {
+		return schema, fmt.Errorf("schema %s requires table, id_column and line_column", FileName)
+	}
+	return schema, nil
+}
+
+// FlightQuery selects the line of the flight $1 as wkb
+func (s *PostgresSchema) FlightQuery() string {
+	return fmt.Sprintf("SELECT ST_AsBinary(%s) FROM %s WHERE %s = $1",
+		pq.QuoteIdentifier(s.LineColumn), quoteTable(s.Table), pq.QuoteIdentifier(s.IDColumn))
+}
+
+// DayQuery selects the ids of the flights of the competition day $1
+func (s *PostgresSchema) DayQuery() string {
+	return fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE %[3]s = $1 ORDER BY %[1]s",
+		pq.QuoteIdentifier(s.IDColumn), quoteTable(s.Table), pq.QuoteIdentifier(s.DayColumn))
+}
+
+// quoteTable quotes the table and its schema
+func quoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// PostgresSource reads the flights from the db with a pool of connections that is
+// shared by all renderings
+type PostgresSource struct {
+	DB     *sql.DB
+	Schema PostgresSchema
+	// DataSource is the connection string, e.g. for listening on notifications
+	DataSource string
+	// Timeout of each query, 0 disables it
+	Timeout time.Duration
+}
+
+// NewPostgresSource opens the pool of connections, the connections are established on the first query
+func NewPostgresSource(DataSource string, Schema PostgresSchema, MaxConnections int, Timeout time.Duration) (*PostgresSource, error) {
+	db, err := sql.Open("postgres", DataSource)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(MaxConnections)
+	db.SetMaxIdleConns(MaxConnections)
+	// connections are renewed, e.g. after a failover of the db
+	db.SetConnMaxLifetime(30 * time.Minute)
+	return &PostgresSource{DB: db, Schema: Schema, DataSource: DataSource, Timeout: Timeout}, nil
+}
+
+// DatabaseFlags are the cli flags of the db connection
+func DatabaseFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "db-url",
+			Usage:   "Connection string of the db, defaults to the POSTGRES_* variables",
+			EnvVars: []string{"CASPER_DB_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "db-schema",
+			Usage:   "Json file mapping the flights to the tables and columns of the db",
+			EnvVars: []string{"CASPER_DB_SCHEMA"},
+		},
+		&cli.IntFlag{
+			Name:    "db-max-connections",
+			Value:   DefaultMaxConnections,
+			Usage:   "Size of the connection pool",
+			EnvVars: []string{"CASPER_DB_MAX_CONNECTIONS"},
+		},
+		&cli.DurationFlag{
+			Name:    "db-timeout",
+			Value:   DefaultQueryTimeout,
+			Usage:   "Timeout of each query, 0 disables it",
+			EnvVars: []string{"CASPER_DB_TIMEOUT"},
+		},
+	}
+}
+
+// ParsePostgresSource creates the source from the DatabaseFlags
+func ParsePostgresSource(c *cli.Context) (*PostgresSource, error) {
+	schema := DefaultPostgresSchema
+	if file := c.String("db-schema"); file != "" {
+		var err error
+		if schema, err = LoadPostgresSchema(file); err != nil {
+			return nil, err
+		}
+	}
+	if c.Int("db-max-connections") < 1 {
+		return nil, fmt.Errorf("db max connections have to be positive")
+	}
+	dataSource := c.String("db-url")
+	if dataSource == "" {
+		dataSource = psqlConnectionString()
+	}
+	return NewPostgresSource(dataSource, schema, c.Int("db-max-connections"), c.Duration("db-timeout"))
+}
+
+// query runs the query with the timeout of the source
+func (s *PostgresSource) query(ctx context.Context, query func(ctx context.Context) error) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	return query(ctx)
+}
+
+// Flight returns the flight with the pilot and the takeoff airport, missing
+// metadata does not fail the flight
+func (s *PostgresSource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	flight := &Flight{ID: FlightID}
+	// parse ST_AsBinary(line) to the line, altitude and time are kept for the coloring
+	err := s.query(ctx, func(ctx context.Context) error {
+		return s.DB.QueryRowContext(ctx, s.Schema.FlightQuery(), FlightID).Scan(&flight.Track)
+	})
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: %d", ErrFlightNotFound, FlightID)
+	} else if err != nil {
+		return nil, err
+	}
+	if len(flight.Track.Line) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrEmptyGeometry, FlightID)
+	}
+
+	if s.Schema.MetadataQuery == "" {
+		return flight, nil
+	}
+	var pilot, airport sql.NullString
+	err = s.query(ctx, func(ctx context.Context) error {
+		return s.DB.QueryRowContext(ctx, s.Schema.MetadataQuery, FlightID).Scan(&pilot, &airport)
+	})
+	if err != nil {
+		log.Printf("Metadata of Flight ID %d not found: %s\n", FlightID, err)
+	}
+	flight.Pilot, flight.Airport = pilot.String, airport.String
+	return flight, nil
+}
+
+// Task returns the declared task of the flight, nil if the flight has no task
+func (s *PostgresSource) Task(ctx context.Context, FlightID uint) (*Task, error) {
+	if s.Schema.TaskQuery == "" {
+		return nil, nil
+	}
+	var data []byte
+	err := s.query(ctx, func(ctx context.Context) error {
+		return s.DB.QueryRowContext(ctx, s.Schema.TaskQuery, FlightID).Scan(&data)
+	})
+	if err == sql.ErrNoRows || (err == nil && data == nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseTask(data)
+}
+
+// CompetitionDay returns the ids of all flights of the competition day
+func (s *PostgresSource) CompetitionDay(ctx context.Context, DayID uint) ([]uint, error) {
+	if s.Schema.DayColumn == "" {
+		return nil, fmt.Errorf("competition days are not available in the schema")
+	}
+	var ids []uint
+	err := s.query(ctx, func(ctx context.Context) error {
+		rows, err := s.DB.QueryContext(ctx, s.Schema.DayQuery(), DayID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uint
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: competition day %d has no flights", ErrFlightNotFound, DayID)
+	}
+	return ids, nil
+}
+
+// FilterFlights returns the ids of the flights matching the sql condition, e.g.
+// "created >= '2021-01-01' AND thumbnail IS NULL". The condition is part of the
+// query as is, so it must only be passed by the operator and never by requests.
+func (s *PostgresSource) FilterFlights(ctx context.Context, Condition string) ([]uint, error) {
+	query := fmt.Sprintf("SELECT %[1]s FROM %[2]s WHERE (%[3]s) ORDER BY %[1]s",
+		pq.QuoteIdentifier(s.Schema.IDColumn), quoteTable(s.Schema.Table), Condition)
+	var ids []uint
+	err := s.query(ctx, func(ctx context.Context) error {
+		rows, err := s.DB.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uint
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	return ids, err
+}
+
+func psqlConnectionString() string {
+	// get environment connection vars
+	var (
+		host     = os.Getenv("POSTGRES_HOST")
+		port     = os.Getenv("POSTGRES_PORT")
+		user     = os.Getenv("POSTGRES_USER")
+		password = os.Getenv("POSTGRES_PASS")
+		dbname   = os.Getenv("POSTGRES_DB")
+	)
+
+	// build connection string
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		host, port, user, password, dbname)
+}
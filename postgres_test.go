@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPostgresSchema(t *testing.T) {
+	query := DefaultPostgresSchema.FlightQuery()
+	if query != `SELECT ST_AsBinary("line_wkt") FROM "flight" WHERE "id" = $1` {
+		t.Errorf("Unexpected flight query: %s", query)
+	}
+
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "schema.json")
+	content := `{"table": "tracks.log", "id_column": "log_id", "line_column": "geom", "task_query": "SELECT data FROM tracks.task WHERE log_id = $1"}`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := LoadPostgresSchema(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// identifiers are quoted, so the schema cannot inject sql
+	query = schema.FlightQuery()
+	if query != `SELECT ST_AsBinary("geom") FROM "tracks"."log" WHERE "log_id" = $1` {
+		t.Errorf("Unexpected flight query: %s", query)
+	}
+	// the default queries of the weglide tables are only taken for the weglide tables
+	if schema.MetadataQuery != "" || schema.DayColumn != "" || schema.TaskQuery != "SELECT data FROM tracks.task WHERE log_id = $1" {
+		t.Errorf("Schema of another table is %+v", schema)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"task_query": ""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if schema, err = LoadPostgresSchema(file); err != nil {
+		t.Fatal(err)
+	}
+	if schema.MetadataQuery != DefaultPostgresSchema.MetadataQuery || schema.DayColumn != DefaultPostgresSchema.DayColumn || schema.TaskQuery != "" {
+		t.Errorf("Schema of the weglide table is %+v", schema)
+	}
+
+	// disabled queries do not reach the db
+	schema.DayColumn = ""
+	source, err := NewPostgresSource("host=invalid", schema, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.DB.Close()
+	if task, err := source.Task(context.Background(), 1); task != nil || err != nil {
+		t.Errorf("Disabled task query returns %v, %v", task, err)
+	}
+	if _, err := source.CompetitionDay(context.Background(), 1); err == nil {
+		t.Errorf("Disabled competition days do not fail")
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"table": ""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPostgresSchema(file); err == nil {
+		t.Errorf("Schema without table is accepted")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Status of a render job in the queue
+const (
+	JobPending string = "pending"
+	JobRunning string = "running"
+	JobDone    string = "done"
+	JobFailed  string = "failed"
+)
+
+const (
+	// DefaultJobTable is the table of the render jobs and the channel notifying about new jobs
+	DefaultJobTable string = "render_job"
+	// DefaultJobLease is the duration after which a running job of a crashed worker is claimed again
+	DefaultJobLease time.Duration = 10 * time.Minute
+)
+
+// Job renders the thumbnail of one flight
+type Job struct {
+	ID       int64
+	FlightID uint
+	// Attempts including the current one
+	Attempts int
+}
+
+// JobQueue hands out the render jobs to the workers, a claimed job is not handed
+// out again until it is retried, released or its lease expires
+type JobQueue interface {
+	// Claim returns the next due job or nil if there is none
+	Claim(ctx context.Context) (*Job, error)
+	// Complete marks the job as done with the location of the image
+	Complete(ctx context.Context, job *Job, Result string) error
+	// Retry records the error and hands out the job again after the delay
+	Retry(ctx context.Context, job *Job, Reason error, Delay time.Duration) error
+	// Fail records the error, the job is not handed out again
+	Fail(ctx context.Context, job *Job, Reason error) error
+	// Release hands out the job again without counting the attempt, e.g. on shutdown
+	Release(ctx context.Context, job *Job) error
+	// Renew extends the lease of the running job
+	Renew(ctx context.Context, job *Job) error
+}
+
+// PostgresQueue is a table of jobs, concurrent workers claim different jobs with
+// FOR UPDATE SKIP LOCKED. See the README for the definition of the table.
+type PostgresQueue struct {
+	DB *sql.DB
+	// Table of the jobs, optionally qualified by the schema like public.render_job
+	Table string
+	// Lease of a running job, 0 never claims running jobs again
+	Lease time.Duration
+}
+
+// NewPostgresQueue uses the pool of connections of the source
+func NewPostgresQueue(Source *PostgresSource, Table string, Lease time.Duration) *PostgresQueue {
+	return &PostgresQueue{DB: Source.DB, Table: Table, Lease: Lease}
+}
+
+func (q *PostgresQueue) Claim(ctx context.Context) (*Job, error) {
+	lease, args := "", []interface{}{}
+	if q.Lease > 0 {
+		lease = fmt.Sprintf(" OR (status = '%s' AND updated_at < now() - $1::float8 * interval '1 second')", JobRunning)
+		args = append(args, q.Lease.Seconds())
+	}
+	query := fmt.Sprintf(`UPDATE %[1]s SET status = '%[2]s', attempts = attempts + 1, updated_at = now()
+		WHERE id = (
+			SELECT id FROM %[1]s WHERE (status = '%[3]s' AND run_at <= now())%[4]s
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, flight_id, attempts`, quoteTable(q.Table), JobRunning, JobPending, lease)
+	job := &Job{}
+	err := q.DB.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.FlightID, &job.Attempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (q *PostgresQueue) Complete(ctx context.Context, job *Job, Result string) error {
+	return q.update(ctx, "status = $2, result = $3, error = NULL", job.ID, JobDone, Result)
+}
+
+func (q *PostgresQueue) Retry(ctx context.Context, job *Job, Reason error, Delay time.Duration) error {
+	return q.update(ctx, "status = $2, error = $3, run_at = now() + $4::float8 * interval '1 second'",
+		job.ID, JobPending, Reason.Error(), Delay.Seconds())
+}
+
+func (q *PostgresQueue) Fail(ctx context.Context, job *Job, Reason error) error {
+	return q.update(ctx, "status = $2, error = $3", job.ID, JobFailed, Reason.Error())
+}
+
+func (q *PostgresQueue) Release(ctx context.Context, job *Job) error {
+	return q.update(ctx, "status = $2, attempts = attempts - 1", job.ID, JobPending)
+}
+
+func (q *PostgresQueue) Renew(ctx context.Context, job *Job) error {
+	query := fmt.Sprintf("UPDATE %s SET updated_at = now() WHERE id = $1 AND status = $2", quoteTable(q.Table))
+	_, err := q.DB.ExecContext(ctx, query, job.ID, JobRunning)
+	return err
+}
+
+// update sets the columns of the job $1
+func (q *PostgresQueue) update(ctx context.Context, set string, args ...interface{}) error {
+	query := fmt.Sprintf("UPDATE %s SET %s, updated_at = now() WHERE id = $1", quoteTable(q.Table), set)
+	_, err := q.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListenJobs sends on the returned channel whenever the channel of the db is notified
+// about new jobs and after reconnects, notifications arriving while the previous one
+// was not received yet are merged. The listener is closed with the context.
+func ListenJobs(ctx context.Context, DataSource string, Channel string) (<-chan struct{}, error) {
+	listener := pq.NewListener(DataSource, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listening on %s: %s\n", Channel, err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	notify := make(chan struct{}, 1)
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			// nil is received after a reconnect, notifications may have been missed meanwhile
+			case <-listener.Notify:
+			}
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return notify, nil
+}
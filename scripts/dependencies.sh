@@ -6,7 +6,8 @@ packages=(
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/encoding/wkb"
 	"github.com/paulmach/orb/geojson"
-	"github.com/oliamb/cutter"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
 )
 for package in ${packages[@]}; do
     echo "go get ${package}"
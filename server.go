@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRenderTimeout limits the rendering of one request, the timeouts of the
+// server connections are derived from it
+const DefaultRenderTimeout time.Duration = time.Minute
+
+// FlightHandler serves rendered flight images under /flights/{id}.png, multiple flights
+// are rendered into one image by /flights/{id},{id}.png and competition days by /days/{id}.png
+type FlightHandler struct {
+	Source   FlightSource
+	Defaults RenderOptions
+	// Timeout of the rendering of a request, 0 disables it
+	Timeout time.Duration
+}
+
+// NewServer returns the http server for the serve command, slow clients are
+// disconnected so that they cannot hold the connections open
+func NewServer(Address string, Source FlightSource, Defaults RenderOptions, Timeout time.Duration) *http.Server {
+	mux := http.NewServeMux()
+	handler := &FlightHandler{Source: Source, Defaults: Defaults, Timeout: Timeout}
+	mux.Handle("/flights/", handler)
+	mux.Handle("/days/", handler)
+	return &http.Server{
+		Addr:              Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		// the response is written after the rendering
+		WriteTimeout: Timeout + 30*time.Second,
+		IdleTimeout:  2 * time.Minute,
+	}
+}
+
+func (h *FlightHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	var FlightIDs []uint
+	var err error
+	if name := strings.TrimPrefix(r.URL.Path, "/days/"); name != r.URL.Path {
+		if !strings.HasSuffix(name, ".png") {
+			http.NotFound(w, r)
+			return
+		}
+		DayID, err := strconv.ParseUint(strings.TrimSuffix(name, ".png"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid competition day id", http.StatusBadRequest)
+			return
+		}
+		if FlightIDs, err = h.Source.CompetitionDay(ctx, uint(DayID)); err != nil {
+			log.Printf("Competition Day ID %d failed: %s\n", DayID, err)
+			status := StatusCode(err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	} else {
+		name := strings.TrimPrefix(r.URL.Path, "/flights/")
+		if !strings.HasSuffix(name, ".png") {
+			http.NotFound(w, r)
+			return
+		}
+		if FlightIDs, err = ParseFlightIDs(strings.TrimSuffix(name, ".png")); err != nil {
+			http.Error(w, "invalid flight id", http.StatusBadRequest)
+			return
+		}
+		if len(FlightIDs) > MaxFlights {
+			http.Error(w, "too many flights", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// query parameters fall back to the defaults of the cli, size is a shorthand for square images
+	Options := h.Defaults
+	query := r.URL.Query()
+	for _, param := range []struct {
+		name   string
+		values []*int
+	}{
+		{"size", []*int{&Options.Width, &Options.Height}},
+		{"width", []*int{&Options.Width}},
+		{"height", []*int{&Options.Height}},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || !ValidSize(size) {
+			http.Error(w, "invalid "+param.name, http.StatusBadRequest)
+			return
+		}
+		for _, v := range param.values {
+			*v = size
+		}
+	}
+	if value := query.Get("dpi"); value != "" {
+		Options.DPI, err = strconv.ParseFloat(value, 64)
+		if err != nil || Options.DPI <= 0 || Options.DPI > MaxDPI {
+			http.Error(w, "invalid dpi", http.StatusBadRequest)
+			return
+		}
+	}
+	// a large image at a high dpi would fetch thousands of tiles
+	if err := CheckPixels(Options.Width, Options.Height, Options.DPI); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("thickness"); value != "" {
+		Options.Line.Width, err = strconv.ParseFloat(value, 64)
+		if err != nil || Options.Line.Width <= 0 {
+			http.Error(w, "invalid thickness", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("color_by"); value != "" {
+		if !ValidColorBy(value) {
+			http.Error(w, "invalid color_by", http.StatusBadRequest)
+			return
+		}
+		Options.ColorBy = value
+	}
+	if value := query.Get("palette"); value != "" {
+		Options.Palette, err = ParsePalette(value)
+		if err != nil {
+			http.Error(w, "invalid palette", http.StatusBadRequest)
+			return
+		}
+	}
+	for _, param := range []struct {
+		name  string
+		value *bool
+	}{
+		{"legend", &Options.Legend},
+		{"task", &Options.ShowTask},
+		{"airport_label", &Options.AirportLabel},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if *param.value, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "invalid "+param.name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the airspaces of the cli can only be disabled
+	if value := query.Get("airspace"); value != "" {
+		show, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "invalid airspace", http.StatusBadRequest)
+			return
+		}
+		if !show {
+			Options.Airspaces = nil
+		}
+	}
+	// icons are only available in the cli, a query must not read files
+	for _, param := range []struct {
+		name   string
+		marker **Marker
+		color  color.NRGBA
+	}{
+		{"start_marker", &Options.StartMarker, StartColor},
+		{"landing_marker", &Options.LandingMarker, LandingColor},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		if !ValidMarkerShape(value) {
+			http.Error(w, "invalid "+param.name, http.StatusBadRequest)
+			return
+		}
+		*param.marker, _ = ParseMarker(value, Options.MarkerSize, param.color)
+	}
+
+	log.Printf("Rendering Flight IDs %v for %s\n", FlightIDs, r.RemoteAddr)
+	buf, degraded, err := h.render(ctx, FlightIDs, Options)
+	if err != nil {
+		log.Printf("Rendering Flight IDs %v failed: %s\n", FlightIDs, err)
+		status := StatusCode(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if len(degraded) > 0 {
+		// the tiles are listed as z/x/y so that the client can decide to retry later
+		tiles := make([]string, len(degraded))
+		for i, tile := range degraded {
+			tiles[i] = fmt.Sprintf("%d/%d/%d", tile.Z, tile.X, tile.Y)
+		}
+		w.Header().Set("X-Degraded-Tiles", strings.Join(tiles, ","))
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, _ = buf.WriteTo(w)
+}
+
+// render encodes the image into a buffer, so that errors can still be reported with a status code
+func (h *FlightHandler) render(ctx context.Context, FlightIDs []uint, Options RenderOptions) (*bytes.Buffer, []*TileError, error) {
+	rendering, err := RenderFlights(ctx, h.Source, FlightIDs, Options)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, rendering.Image); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrEncode, err)
+	}
+	return buf, rendering.Degraded, nil
+}
+
+// StatusCode maps an error of the pipeline to the http status of the response
+func StatusCode(err error) int {
+	switch {
+	case errors.Is(err, ErrFlightNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrImageTooLarge):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrEmptyGeometry):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrTileFetch), errors.Is(err, ErrTileDecode):
+		return http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// SlowSource waits for the cancellation of the request like a stuck db
+type SlowSource struct {
+	FlightSource
+}
+
+func (s *SlowSource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestFlightHandler(t *testing.T) {
+	server := NewSolidTileServer(t, color.White)
+	defer server.Close()
+	handler := &FlightHandler{Source: NewTestSource(), Defaults: NewTestOptions(server)}
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/flights/1.png?width=64&height=32", http.StatusOK},
+		{"/flights/1,2.png?legend=true", http.StatusOK},
+		{"/days/10.png", http.StatusOK},
+		{"/days/11.png", http.StatusNotFound},
+		{"/flights/4.png", http.StatusNotFound},
+		{"/flights/3.png", http.StatusUnprocessableEntity},
+		{"/flights/a.png", http.StatusBadRequest},
+		{"/flights/1.png?width=0", http.StatusBadRequest},
+		{"/flights/1.png?size=4096&dpi=384", http.StatusBadRequest},
+		{"/flights/1.png?color_by=temperature", http.StatusBadRequest},
+		{"/flights/1.jpeg", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if recorder.Code != c.status {
+			t.Errorf("%s: status is %d, expected %d", c.path, recorder.Code, c.status)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/flights/1.png?width=64&height=32", nil))
+	im, err := png.Decode(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := im.Bounds().Size(); size.X != 64 || size.Y != 32 {
+		t.Errorf("Image has size %v", size)
+	}
+
+	// renderings exceeding the timeout are cancelled
+	handler = &FlightHandler{Source: &SlowSource{NewTestSource()}, Defaults: NewTestOptions(server), Timeout: 10 * time.Millisecond}
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/flights/1.png", nil))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("Rendering exceeding the timeout returns %d", recorder.Code)
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	server := NewServer(":8080", NewTestSource(), RenderOptions{}, DefaultRenderTimeout)
+	if server.ReadHeaderTimeout <= 0 || server.ReadTimeout <= 0 || server.IdleTimeout <= 0 || server.WriteTimeout <= DefaultRenderTimeout {
+		t.Errorf("Server does not limit slow clients: %+v", server)
+	}
+}
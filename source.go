@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// FlightSource provides the flights with their geometry, bbox and metadata to the
+// renderer, so that it does not depend on a specific backend
+type FlightSource interface {
+	// Flight returns the flight with its track, ErrFlightNotFound if it does not exist
+	Flight(ctx context.Context, FlightID uint) (*Flight, error)
+	// Task returns the declared task of the flight, nil if it has none
+	Task(ctx context.Context, FlightID uint) (*Task, error)
+	// CompetitionDay returns the ids of all flights of the competition day
+	CompetitionDay(ctx context.Context, DayID uint) ([]uint, error)
+}
+
+// MemorySource keeps the flights in memory, e.g. for tests
+type MemorySource struct {
+	Flights map[uint]*Flight
+	Tasks   map[uint]*Task
+	Days    map[uint][]uint
+}
+
+// NewMemorySource returns the source of the flights, their ids are used as keys
+func NewMemorySource(flights ...*Flight) *MemorySource {
+	source := &MemorySource{Flights: make(map[uint]*Flight), Tasks: make(map[uint]*Task), Days: make(map[uint][]uint)}
+	for _, flight := range flights {
+		source.Flights[flight.ID] = flight
+	}
+	return source
+}
+
+// Flight returns a copy of the flight, the track is shared and must not be modified
+func (s *MemorySource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	flight, ok := s.Flights[FlightID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrFlightNotFound, FlightID)
+	}
+	if len(flight.Track.Line) == 0 {
+		return nil, fmt.Errorf("%w: %d", ErrEmptyGeometry, FlightID)
+	}
+	copied := *flight
+	return &copied, nil
+}
+
+// Task returns the task of the flight
+func (s *MemorySource) Task(ctx context.Context, FlightID uint) (*Task, error) {
+	return s.Tasks[FlightID], nil
+}
+
+// CompetitionDay returns the flights of the competition day
+func (s *MemorySource) CompetitionDay(ctx context.Context, DayID uint) ([]uint, error) {
+	ids, ok := s.Days[DayID]
+	if !ok || len(ids) == 0 {
+		return nil, fmt.Errorf("%w: competition day %d has no flights", ErrFlightNotFound, DayID)
+	}
+	return ids, nil
+}
+
+// IDs returns the sorted ids of all flights
+func (s *MemorySource) IDs() []uint {
+	ids := make([]uint, 0, len(s.Flights))
+	for id := range s.Flights {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// FileSource provides the flights of a file, they are numbered from 1 in the order
+// of the file and share the task declared by the file
+type FileSource struct {
+	*MemorySource
+	FileName string
+}
+
+// NewFileSource reads the flights of the file like LoadFlights
+func NewFileSource(FileName string, format string) (*FileSource, error) {
+	flights, task, err := LoadFlights(FileName, format)
+	if err != nil {
+		return nil, err
+	}
+	source := &FileSource{MemorySource: NewMemorySource(), FileName: FileName}
+	for i, flight := range flights {
+		flight.ID = uint(i + 1)
+		source.Flights[flight.ID] = flight
+		source.Tasks[flight.ID] = task
+	}
+	return source, nil
+}
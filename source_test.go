@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+// NewTestOptions returns options rendering small images from the tile server without cache
+func NewTestOptions(server *httptest.Server) RenderOptions {
+	return RenderOptions{
+		Line:      LineStyle{Width: 3, Color: TrackColor},
+		Width:     160,
+		Height:    90,
+		Padding:   BufferforCropping,
+		DPI:       DefaultDPI,
+		Source:    &XYZSource{ID: "test", Template: server.URL + "/{z}/{x}/{y}.png", Size: 256, Zoom: 18, ImageFormat: "png"},
+		Cache:     NewTileCache("", 0, 0),
+		Fallback:  FallbackNone,
+		TaskColor: TaskColor,
+	}
+}
+
+func NewTestSource() *MemorySource {
+	source := NewMemorySource(
+		&Flight{ID: 1, Pilot: "Otto Lilienthal", Track: Track{Line: orb.LineString{{13.29, 52.56}, {12.55, 52.41}, {12.81, 52.93}}}},
+		&Flight{ID: 2, Track: Track{Line: orb.LineString{{13.0, 52.0}, {13.5, 52.5}}}},
+		&Flight{ID: 3},
+	)
+	source.Days[10] = []uint{1, 2}
+	return source
+}
+
+func TestMemorySource(t *testing.T) {
+	source := NewTestSource()
+	flight, err := source.Flight(context.Background(), 1)
+	if err != nil || flight.Pilot != "Otto Lilienthal" {
+		t.Errorf("Flight is %+v: %v", flight, err)
+	}
+	if _, err := source.Flight(context.Background(), 4); !errors.Is(err, ErrFlightNotFound) {
+		t.Errorf("Unknown flight should not be found: %v", err)
+	}
+	if _, err := source.Flight(context.Background(), 3); !errors.Is(err, ErrEmptyGeometry) {
+		t.Errorf("Flight without line should be empty: %v", err)
+	}
+	if ids, err := source.CompetitionDay(context.Background(), 10); err != nil || len(ids) != 2 {
+		t.Errorf("Competition day is %v: %v", ids, err)
+	}
+	if ids := source.IDs(); len(ids) != 3 || ids[0] != 1 || ids[2] != 3 {
+		t.Errorf("IDs are %v", ids)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	source, err := NewFileSource("testdata/berlin.igc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := source.IDs(); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("IDs are %v", ids)
+	}
+	if task, err := source.Task(context.Background(), 1); err != nil || task == nil {
+		t.Errorf("Declared task is not provided: %v", err)
+	}
+}
+
+func TestRenderFlights(t *testing.T) {
+	background := color.NRGBA{0xf0, 0xf0, 0xf0, 0xff}
+	server := NewSolidTileServer(t, background)
+	defer server.Close()
+	source := NewTestSource()
+	Options := NewTestOptions(server)
+	Options.Legend = true
+
+	rendering, err := RenderFlights(context.Background(), source, []uint{1, 2}, Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := rendering.Image.Bounds().Size(); size.X != 160 || size.Y != 90 {
+		t.Errorf("Image has size %v", size)
+	}
+	// the flights of the source are not modified by the rendering
+	if line := source.Flights[1].Track.Line; line[0] != (orb.Point{13.29, 52.56}) {
+		t.Errorf("Flight is modified: %v", line)
+	}
+	if _, err := RenderFlights(context.Background(), source, []uint{1, 4}, Options); !errors.Is(err, ErrFlightNotFound) {
+		t.Errorf("Unknown flight should fail: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+// Observation zones of the turnpoints
+const (
+	ZoneCylinder string = "cylinder"
+	ZoneLine     string = "line"
+	ZoneSector   string = "sector"
+)
+
+const (
+	// DefaultZoneRadius in meters is used for turnpoints without radius
+	DefaultZoneRadius float64 = 500
+	// DefaultSectorAngle in degrees is the angle of the FAI sector
+	DefaultSectorAngle float64 = 90
+	// ZoneSegments is the number of segments a full circle is approximated with
+	ZoneSegments int = 72
+	// TaskWidth is the line width of the task in pixels of the image
+	TaskWidth float64 = 1.5
+)
+
+// TaskColor is the default color of the task
+var TaskColor = color.NRGBA{0xc2, 0x18, 0x5b, 0xff}
+
+// Task is the declared task of a flight, the first turnpoint is the start
+// and the last one the finish
+type Task struct {
+	Name       string      `json:"name"`
+	Turnpoints []Turnpoint `json:"turnpoints"`
+}
+
+// Turnpoint with its observation zone, the radius of a line is half its length
+type Turnpoint struct {
+	Name   string  `json:"name"`
+	Lon    float64 `json:"lon"`
+	Lat    float64 `json:"lat"`
+	Type   string  `json:"type"`
+	Radius float64 `json:"radius"`
+	// Angle of a sector in degrees
+	Angle float64 `json:"angle"`
+}
+
+// ParseTask decodes a task from json and validates the observation zones
+func ParseTask(data []byte) (*Task, error) {
+	var task Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, fmt.Errorf("invalid task: %w", err)
+	}
+	if len(task.Turnpoints) < 2 {
+		return nil, fmt.Errorf("task requires at least two turnpoints")
+	}
+	for i := range task.Turnpoints {
+		tp := &task.Turnpoints[i]
+		switch tp.Type {
+		case "":
+			tp.Type = ZoneCylinder
+		case ZoneCylinder, ZoneLine, ZoneSector:
+		default:
+			return nil, fmt.Errorf("unknown observation zone %q of turnpoint %d", tp.Type, i)
+		}
+		if tp.Radius <= 0 {
+			tp.Radius = DefaultZoneRadius
+		}
+		if tp.Angle <= 0 || tp.Angle > 360 {
+			tp.Angle = DefaultSectorAngle
+		}
+	}
+	return &task, nil
+}
+
+// LoadTask reads the task from a json file
+func LoadTask(FileName string) (*Task, error) {
+	content, err := ioutil.ReadFile(FileName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTask(content)
+}
+
+// Points returns the centers of the turnpoints
+func (t *Task) Points() orb.LineString {
+	points := make(orb.LineString, len(t.Turnpoints))
+	for i, tp := range t.Turnpoints {
+		points[i] = orb.Point{tp.Lon, tp.Lat}
+	}
+	return points
+}
+
+// Align unwraps the task like the flights and shifts it next to the given longitude
+func (t *Task) Align(lon float64) *Task {
+	points := AlignLine(UnwrapLine(t.Points()), lon)
+	aligned := &Task{Name: t.Name, Turnpoints: make([]Turnpoint, len(t.Turnpoints))}
+	for i, tp := range t.Turnpoints {
+		tp.Lon = points[i][0]
+		aligned.Turnpoints[i] = tp
+	}
+	return aligned
+}
+
+// Zone returns the observation zone of the turnpoint as ring, or as line string for lines
+func (t *Task) Zone(i int) orb.Geometry {
+	tp := t.Turnpoints[i]
+	center := orb.Point{tp.Lon, tp.Lat}
+	if tp.Type == ZoneCylinder {
+		return arc(center, tp.Radius, 0, 360, true)
+	}
+	// the zones face away from the course
+	axis := t.outward(i)
+	if tp.Type == ZoneLine {
+		return orb.LineString{Destination(center, axis-90, tp.Radius), Destination(center, axis+90, tp.Radius)}
+	}
+	ring := orb.Ring{center}
+	ring = append(ring, arc(center, tp.Radius, axis-tp.Angle/2, axis+tp.Angle/2, false)...)
+	return append(ring, center)
+}
+
+// outward returns the bearing of the bisector of the legs pointing away from the course
+func (t *Task) outward(i int) float64 {
+	points := t.Points()
+	switch i {
+	case 0:
+		return geo.Bearing(points[0], points[1]) + 180
+	case len(points) - 1:
+		return geo.Bearing(points[i], points[i-1]) + 180
+	}
+	in, out := geo.Bearing(points[i], points[i-1])*math.Pi/180, geo.Bearing(points[i], points[i+1])*math.Pi/180
+	x, y := math.Sin(in)+math.Sin(out), math.Cos(in)+math.Cos(out)
+	if math.Abs(x) < 1e-9 && math.Abs(y) < 1e-9 {
+		// straight course, the zone is perpendicular to it
+		return geo.Bearing(points[i], points[i-1]) + 90
+	}
+	return math.Atan2(x, y)*180/math.Pi + 180
+}
+
+// Bound returns the extent of the task including all observation zones
+func (t *Task) Bound() orb.Bound {
+	bound := t.Points().Bound()
+	for i := range t.Turnpoints {
+		bound = bound.Union(t.Zone(i).Bound())
+	}
+	return bound
+}
+
+// Destination returns the point at the distance in meters and the bearing in degrees,
+// the longitude is not wrapped at the antimeridian
+func Destination(start orb.Point, bearing float64, distance float64) orb.Point {
+	d := distance / orb.EarthRadius
+	theta := bearing * math.Pi / 180
+	lat1, lon1 := start[1]*math.Pi/180, start[0]*math.Pi/180
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return orb.Point{lon2 * 180 / math.Pi, lat2 * 180 / math.Pi}
+}
+
+// arc approximates the arc from the start to the end bearing, it runs clockwise
+// if end is larger than start and counterclockwise otherwise
+func arc(center orb.Point, radius float64, start float64, end float64, closed bool) orb.Ring {
+	steps := int(math.Ceil(math.Abs(end-start) / 360 * float64(ZoneSegments)))
+	if steps < 1 {
+		steps = 1
+	}
+	ring := make(orb.Ring, 0, steps+1)
+	for i := 0; i <= steps; i++ {
+		if closed && i == steps {
+			ring = append(ring, ring[0])
+			break
+		}
+		ring = append(ring, Destination(center, start+(end-start)*float64(i)/float64(steps), radius))
+	}
+	return ring
+}
+
+// DrawTask draws the observation zones and the legs as dashed lines
+func DrawTask(dc *gg.Context, grid *TileGrid, task *Task, c color.NRGBA, width float64) {
+	dc.Push()
+	defer dc.Pop()
+	dc.SetLineJoin(gg.LineJoinRound)
+	dc.SetLineCap(gg.LineCapButt)
+	dc.SetLineWidth(width)
+
+	fill := c
+	fill.A = 0x30
+	for i := range task.Turnpoints {
+		dc.NewSubPath()
+		switch zone := task.Zone(i).(type) {
+		case orb.Ring:
+			tracePoints(dc, grid, zone)
+			dc.ClosePath()
+			dc.SetColor(fill)
+			dc.FillPreserve()
+		case orb.LineString:
+			tracePoints(dc, grid, zone)
+		}
+		dc.SetColor(c)
+		dc.Stroke()
+	}
+
+	dc.SetDash(4*width, 3*width)
+	dc.NewSubPath()
+	tracePoints(dc, grid, task.Points())
+	dc.SetColor(c)
+	dc.Stroke()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geo"
+)
+
+func TestDestination(t *testing.T) {
+	start := orb.Point{13.4, 52.5}
+	for _, bearing := range []float64{0, 45, 90, 180, 270} {
+		point := Destination(start, bearing, 10000)
+		if distance := geo.DistanceHaversine(start, point); math.Abs(distance-10000) > 1 {
+			t.Errorf("Distance at bearing %v is %v", bearing, distance)
+		}
+		if b := math.Mod(geo.Bearing(start, point)+360, 360); math.Abs(b-bearing) > 0.1 {
+			t.Errorf("Bearing is %v, expected %v", b, bearing)
+		}
+	}
+	// the longitude continues beyond the antimeridian
+	if point := Destination(orb.Point{179.99, -44}, 90, 10000); point[0] <= 180 {
+		t.Errorf("Longitude is wrapped: %v", point)
+	}
+}
+
+func TestParseTask(t *testing.T) {
+	content, err := ioutil.ReadFile("events/task.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := ParseTask(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Turnpoints) != 4 || task.Turnpoints[1].Angle != DefaultSectorAngle {
+		t.Errorf("Task is %+v", task)
+	}
+	for _, invalid := range []string{`{}`, `{"turnpoints": [{}, {"type": "keyhole"}]}`, `[`} {
+		if _, err := ParseTask([]byte(invalid)); err == nil {
+			t.Errorf("Task %s should be invalid", invalid)
+		}
+	}
+	// missing values use the defaults
+	task, err = ParseTask([]byte(`{"turnpoints": [{"lon": 1}, {"lon": 2}]}`))
+	if err != nil || task.Turnpoints[0].Type != ZoneCylinder || task.Turnpoints[0].Radius != DefaultZoneRadius {
+		t.Errorf("Defaults are not set: %+v %v", task, err)
+	}
+}
+
+func TestTaskZones(t *testing.T) {
+	// start in the west, turnpoint in the north, finish in the east
+	task := &Task{Turnpoints: []Turnpoint{
+		{Lon: 0, Lat: 0, Type: ZoneLine, Radius: 1000},
+		{Lon: 1, Lat: 1, Type: ZoneSector, Radius: 1000, Angle: 90},
+		{Lon: 2, Lat: 0, Type: ZoneCylinder, Radius: 1000},
+	}}
+	start, ok := task.Zone(0).(orb.LineString)
+	if !ok || len(start) != 2 {
+		t.Fatalf("Start is %v", task.Zone(0))
+	}
+	// the start line is perpendicular to the first leg
+	if angle := math.Abs(math.Mod(geo.Bearing(start[0], start[1])-geo.Bearing(orb.Point{0, 0}, orb.Point{1, 1})+360, 180)); math.Abs(angle-90) > 0.5 {
+		t.Errorf("Start line is at %v° to the leg", angle)
+	}
+	// the sector faces north, away from the course
+	sector := task.Zone(1).(orb.Ring)
+	if center := sector[len(sector)/2]; center[1] <= 1 || math.Abs(center[0]-1) > 0.001 {
+		t.Errorf("Sector faces %v", center)
+	}
+	cylinder := task.Zone(2).(orb.Ring)
+	if !cylinder.Closed() || len(cylinder) != ZoneSegments+1 {
+		t.Errorf("Cylinder has %d points", len(cylinder))
+	}
+	bound := task.Bound()
+	if bound.Max[0] <= 2 || bound.Min[1] >= 0 {
+		t.Errorf("Bound does not contain the zones: %v", bound)
+	}
+}
+
+func TestTaskAlign(t *testing.T) {
+	task := &Task{Turnpoints: []Turnpoint{{Lon: -176.5, Lat: -44}, {Lon: 179, Lat: -43}}}
+	aligned := task.Align(176.5)
+	if aligned.Turnpoints[0].Lon != 183.5 || aligned.Turnpoints[1].Lon != 179 {
+		t.Errorf("Task is not aligned: %+v", aligned.Turnpoints)
+	}
+	if task.Turnpoints[0].Lon != -176.5 {
+		t.Error("Task is modified")
+	}
+}
+
+func TestDrawTask(t *testing.T) {
+	grid := &TileGrid{Z: 0, Columns: 1, Rows: 1, TileSize: 256, Section: image.Rect(0, 0, 256, 256)}
+	task := &Task{Turnpoints: []Turnpoint{
+		{Lon: -90, Lat: 0, Type: ZoneCylinder, Radius: 3000000},
+		{Lon: 90, Lat: 0, Type: ZoneCylinder, Radius: 3000000},
+	}}
+	dc := gg.NewContext(256, 256)
+	DrawTask(dc, grid, task, TaskColor, 2)
+	// the center of the cylinder is filled transparent
+	if _, _, _, a := dc.Image().At(64, 118).RGBA(); a == 0 || a == 0xffff {
+		t.Errorf("Cylinder fill has alpha %v", a)
+	}
+	// the leg is dashed
+	var dashed, solid int
+	for x := 100; x < 156; x++ {
+		if color.NRGBAModel.Convert(dc.Image().At(x, 128)) == TaskColor {
+			solid++
+		} else {
+			dashed++
+		}
+	}
+	if solid == 0 || dashed == 0 {
+		t.Errorf("Leg is not dashed: %d solid and %d empty pixels", solid, dashed)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TileSource describes a raster tile server
+type TileSource interface {
+	// Name identifies the source, e.g. in file names of downloaded tiles
+	Name() string
+	// URL returns the url of the tile in the XYZ (slippy map) numbering
+	URL(z int16, x int16, y int16) string
+	// TileSize is the width and height of a tile in pixels
+	TileSize() int
+	MaxZoom() int16
+	// Format is the image format of the tiles, e.g. jpeg or png
+	Format() string
+	Attribution() string
+}
+
+// XYZSource is a tile server with the slippy map numbering, the url template
+// contains the placeholders {z}, {x} and {y}
+type XYZSource struct {
+	ID          string
+	Template    string
+	Size        int
+	Zoom        int16
+	ImageFormat string
+	Credit      string
+}
+
+func (s *XYZSource) Name() string        { return s.ID }
+func (s *XYZSource) TileSize() int       { return s.Size }
+func (s *XYZSource) MaxZoom() int16      { return s.Zoom }
+func (s *XYZSource) Format() string      { return s.ImageFormat }
+func (s *XYZSource) Attribution() string { return s.Credit }
+
+func (s *XYZSource) URL(z int16, x int16, y int16) string {
+	return strings.NewReplacer(
+		"{z}", strconv.Itoa(int(z)),
+		"{x}", strconv.Itoa(int(x)),
+		"{y}", strconv.Itoa(int(y)),
+	).Replace(s.Template)
+}
+
+// TMSSource is a tile server with the TMS numbering, the y axis starts at the bottom
+type TMSSource struct {
+	XYZSource
+}
+
+func (s *TMSSource) URL(z int16, x int16, y int16) string {
+	return s.XYZSource.URL(z, x, int16(1<<uint(z))-1-y)
+}
+
+// WMTSSource is a OGC WMTS server using a GoogleMapsCompatible tile matrix set.
+// The template is either a RESTful url with the placeholders {TileMatrix}, {TileRow} and
+// {TileCol} or the service endpoint which is queried with KVP GetTile requests.
+type WMTSSource struct {
+	XYZSource
+	Layer         string
+	Style         string
+	TileMatrixSet string
+}
+
+func (s *WMTSSource) URL(z int16, x int16, y int16) string {
+	if strings.Contains(s.Template, "{TileMatrix}") {
+		return strings.NewReplacer(
+			"{TileMatrix}", strconv.Itoa(int(z)),
+			"{TileRow}", strconv.Itoa(int(y)),
+			"{TileCol}", strconv.Itoa(int(x)),
+			"{Layer}", s.Layer,
+			"{Style}", s.Style,
+			"{TileMatrixSet}", s.TileMatrixSet,
+		).Replace(s.Template)
+	}
+	separator := "?"
+	if strings.Contains(s.Template, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%sSERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&LAYER=%s&STYLE=%s&TILEMATRIXSET=%s&TILEMATRIX=%d&TILEROW=%d&TILECOL=%d&FORMAT=image/%s",
+		s.Template, separator, s.Layer, s.Style, s.TileMatrixSet, z, y, x, s.ImageFormat)
+}
+
+// TileSources are the built-in sources selectable by name
+var TileSources = map[string]TileSource{
+	"hypsometric": &XYZSource{
+		ID:          "hypsometric",
+		Template:    URLPrefix + "/{z}/{x}/{y}.jpeg",
+		Size:        512,
+		Zoom:        11,
+		ImageFormat: "jpeg",
+		Credit:      "© glidercheck.com",
+	},
+	"osm": &XYZSource{
+		ID:          "osm",
+		Template:    "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+		Size:        256,
+		Zoom:        19,
+		ImageFormat: "png",
+		Credit:      "© OpenStreetMap contributors",
+	},
+	"satellite": &XYZSource{
+		ID:          "satellite",
+		Template:    "https://server.arcgisonline.com/ArcGIS/rest/services/World_Imagery/MapServer/tile/{z}/{y}/{x}",
+		Size:        256,
+		Zoom:        19,
+		ImageFormat: "jpeg",
+		Credit:      "Esri, Maxar, Earthstar Geographics, and the GIS User Community",
+	},
+}
+
+// DefaultTileSource is used if no source is selected
+const DefaultTileSource = "hypsometric"
+
+// TileSourceNames returns the sorted names of the built-in sources
+func TileSourceNames() []string {
+	names := make([]string, 0, len(TileSources))
+	for name := range TileSources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TileSourceConfig is the json definition of a custom tile source
+type TileSourceConfig struct {
+	Name string `json:"name"`
+	// Scheme is one of xyz, tms or wmts, the url of MBTiles and PMTiles archives is their path
+	Scheme        string `json:"scheme"`
+	URL           string `json:"url"`
+	TileSize      int    `json:"tile_size"`
+	MaxZoom       int16  `json:"max_zoom"`
+	Format        string `json:"format"`
+	Attribution   string `json:"attribution"`
+	Layer         string `json:"layer"`
+	Style         string `json:"style"`
+	TileMatrixSet string `json:"tile_matrix_set"`
+	// VectorStyle is the json file with the style of vector tiles, the format pbf or mvt
+	// marks vector tiles, which are drawn with the default style if no style is given
+	VectorStyle string `json:"vector_style"`
+	// Base is the name of a built-in raster source beneath vector tiles
+	Base string `json:"base"`
+	// Layers turns the source into a stack of the layers, which are composited in order
+	Layers []LayerConfig `json:"layers"`
+}
+
+// Source creates the tile source of the config
+func (c *TileSourceConfig) Source() (TileSource, error) {
+	if len(c.Layers) > 0 {
+		return c.stackSource()
+	}
+	if c.Name == "" || c.URL == "" {
+		return nil, fmt.Errorf("tile source requires a name and an url")
+	}
+	base := XYZSource{
+		ID:          c.Name,
+		Template:    c.URL,
+		Size:        c.TileSize,
+		Zoom:        c.MaxZoom,
+		ImageFormat: c.Format,
+		Credit:      c.Attribution,
+	}
+	var archive TileArchive
+	if IsTileArchive(c.URL) {
+		var err error
+		if archive, err = OpenTileArchive(c.URL); err != nil {
+			return nil, err
+		}
+		// the metadata of the archive completes the config
+		info := archive.Info()
+		if base.ImageFormat == "" {
+			base.ImageFormat = info.Format
+		}
+		if base.Zoom == 0 {
+			base.Zoom = info.MaxZoom
+		}
+		if base.Credit == "" {
+			base.Credit = info.Attribution
+		}
+	}
+	vector := IsVectorFormat(base.ImageFormat)
+	if base.Size == 0 && vector {
+		base.Size = DefaultVectorTileSize
+	} else if base.Size == 0 {
+		base.Size = 256
+	}
+	if base.Zoom == 0 {
+		base.Zoom = 18
+	}
+	if base.ImageFormat == "" {
+		base.ImageFormat = "png"
+	}
+	var source TileSource
+	switch strings.ToLower(c.Scheme) {
+	case "", "xyz", "mbtiles", "pmtiles":
+		if archive != nil {
+			source = &ArchiveSource{base, archive}
+			break
+		}
+		source = &base
+	case "tms":
+		source = &TMSSource{base}
+	case "wmts":
+		if c.TileMatrixSet == "" {
+			c.TileMatrixSet = "GoogleMapsCompatible"
+		}
+		if c.Style == "" {
+			c.Style = "default"
+		}
+		source = &WMTSSource{base, c.Layer, c.Style, c.TileMatrixSet}
+	default:
+		return nil, fmt.Errorf("unknown tile scheme %q", c.Scheme)
+	}
+	if !vector {
+		return source, nil
+	}
+	return c.vectorSource(source)
+}
+
+// stackSource creates the layer stack, the tiles have the size of the first layer if no size is given
+func (c *TileSourceConfig) stackSource() (TileSource, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("layer stack requires a name")
+	}
+	stack := &LayerStack{ID: c.Name, Size: c.TileSize}
+	for i := range c.Layers {
+		layer, err := c.Layers[i].Layer()
+		if err != nil {
+			return nil, fmt.Errorf("layer %d of %s: %w", i+1, c.Name, err)
+		}
+		stack.Layers = append(stack.Layers, layer)
+	}
+	if stack.Size == 0 {
+		stack.Size = stack.Layers[0].Source.TileSize()
+	}
+	return stack, nil
+}
+
+// vectorSource wraps the source of the vector tiles with the style and the base
+func (c *TileSourceConfig) vectorSource(tiles TileSource) (TileSource, error) {
+	source := &VectorSource{TileSource: tiles, Style: DefaultVectorStyle}
+	if c.VectorStyle != "" {
+		style, err := LoadVectorStyle(c.VectorStyle)
+		if err != nil {
+			return nil, err
+		}
+		source.Style = style
+	}
+	if c.Base != "" {
+		base, ok := TileSources[c.Base]
+		if !ok {
+			return nil, fmt.Errorf("unknown base tile source %q, available: %s", c.Base, strings.Join(TileSourceNames(), ", "))
+		}
+		source.Base = base
+	}
+	return source, nil
+}
+
+// LoadTileSource returns the source from the json config file if given or the built-in source by name
+func LoadTileSource(name string, ConfigFile string) (TileSource, error) {
+	if ConfigFile != "" {
+		content, err := ioutil.ReadFile(ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		var config TileSourceConfig
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, fmt.Errorf("invalid tile source config %s: %w", ConfigFile, err)
+		}
+		return config.Source()
+	}
+	if name == "" {
+		name = DefaultTileSource
+	}
+	source, ok := TileSources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tile source %q, available: %s", name, strings.Join(TileSourceNames(), ", "))
+	}
+	return source, nil
+}
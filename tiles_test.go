@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTileSourceURL(t *testing.T) {
+	base := XYZSource{ID: "test", Template: "https://tiles.example.com/{z}/{x}/{y}.png", Size: 256, Zoom: 18, ImageFormat: "png"}
+	cases := []struct {
+		source TileSource
+		url    string
+	}{
+		{&base, "https://tiles.example.com/4/8/5.png"},
+		// TMS flips the y axis: 2^4 - 1 - 5
+		{&TMSSource{base}, "https://tiles.example.com/4/8/10.png"},
+		{&WMTSSource{XYZSource{Template: "https://wmts.example.com/{Layer}/{TileMatrixSet}/{TileMatrix}/{TileRow}/{TileCol}.png"}, "relief", "default", "GoogleMapsCompatible"},
+			"https://wmts.example.com/relief/GoogleMapsCompatible/4/5/8.png"},
+		{&WMTSSource{XYZSource{Template: "https://wmts.example.com/service?token=1", ImageFormat: "png"}, "relief", "default", "GoogleMapsCompatible"},
+			"https://wmts.example.com/service?token=1&SERVICE=WMTS&REQUEST=GetTile&VERSION=1.0.0&LAYER=relief&STYLE=default&TILEMATRIXSET=GoogleMapsCompatible&TILEMATRIX=4&TILEROW=5&TILECOL=8&FORMAT=image/png"},
+	}
+	for _, c := range cases {
+		if url := c.source.URL(4, 8, 5); url != c.url {
+			t.Errorf("URL is not matching, expected %s, current value %s", c.url, url)
+		}
+	}
+}
+
+func TestLoadTileSource(t *testing.T) {
+	source, err := LoadTileSource("", "")
+	if err != nil || source.Name() != DefaultTileSource {
+		t.Errorf("Default tile source is not %s", DefaultTileSource)
+	}
+	if _, err := LoadTileSource("unknown", ""); err == nil {
+		t.Errorf("Unknown tile source does not return an error")
+	}
+	config := TileSourceConfig{Name: "tms", Scheme: "tms", URL: "https://tiles.example.com/{z}/{x}/{y}.jpeg"}
+	source, err = config.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := source.(*TMSSource); !ok || source.TileSize() != 256 {
+		t.Errorf("Config does not create a TMS source with the default tile size")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+)
+
+// LineStyle configures how the track is drawn, the widths are given in pixels of the image
+type LineStyle struct {
+	Width float64
+	Join  gg.LineJoin
+	Cap   gg.LineCap
+	Color color.Color
+	// HaloWidth is the width of the contrasting outline on each side of the line, 0 disables it
+	HaloWidth float64
+	HaloColor color.Color
+}
+
+// TrackColor is the default color of the track
+var TrackColor = color.RGBA{uint8(ColorRed * ColorScale), uint8(ColorGreen * ColorScale), uint8(ColorBlue * ColorScale), 0xff}
+
+// DrawTrack strokes the line as one path onto the merged tiles of the grid, scale is the
+// ratio of pixels of the merged tiles to pixels of the image
+func DrawTrack(dc *gg.Context, grid *TileGrid, line orb.LineString, style LineStyle, scale float64) {
+	dc.Push()
+	defer dc.Pop()
+	dc.SetLineJoin(style.Join)
+	dc.SetLineCap(style.Cap)
+	if style.HaloWidth > 0 {
+		tracePath(dc, grid, line, (style.Width+2*style.HaloWidth)*scale)
+		dc.SetColor(style.HaloColor)
+		dc.Stroke()
+	}
+	tracePath(dc, grid, line, style.Width*scale)
+	dc.SetColor(style.Color)
+	dc.Stroke()
+}
+
+// tracePath adds the line to the path of the context, a single fix is drawn as dot
+func tracePath(dc *gg.Context, grid *TileGrid, line orb.LineString, width float64) {
+	dc.SetLineWidth(width)
+	dc.NewSubPath()
+	tracePoints(dc, grid, line)
+	if len(line) == 1 {
+		x, y := grid.Pixel(line[0][0], line[0][1])
+		dc.DrawCircle(x, y, width/2)
+	}
+}
+
+// tracePoints adds the points as connected path
+func tracePoints(dc *gg.Context, grid *TileGrid, points []orb.Point) {
+	for i, point := range points {
+		x, y := grid.Pixel(point[0], point[1])
+		if i == 0 {
+			dc.MoveTo(x, y)
+		} else {
+			dc.LineTo(x, y)
+		}
+	}
+}
+
+// ParseLineJoin parses round or bevel
+func ParseLineJoin(value string) (gg.LineJoin, error) {
+	switch strings.ToLower(value) {
+	case "round":
+		return gg.LineJoinRound, nil
+	case "bevel":
+		return gg.LineJoinBevel, nil
+	}
+	return 0, fmt.Errorf("unknown line join %q, available: round, bevel", value)
+}
+
+// ParseLineCap parses round, butt or square
+func ParseLineCap(value string) (gg.LineCap, error) {
+	switch strings.ToLower(value) {
+	case "round":
+		return gg.LineCapRound, nil
+	case "butt":
+		return gg.LineCapButt, nil
+	case "square":
+		return gg.LineCapSquare, nil
+	}
+	return 0, fmt.Errorf("unknown line cap %q, available: round, butt, square", value)
+}
+
+// ParseHexColor parses colors like #2d55a6 or #2d55a680 with alpha
+func ParseHexColor(value string) (color.Color, error) {
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return nil, fmt.Errorf("invalid color %q", value)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color %q", value)
+	}
+	// the color is not premultiplied, the drawing functions of gg expect color.NRGBA
+	return color.NRGBA{uint8(rgba >> 24), uint8(rgba >> 16), uint8(rgba >> 8), uint8(rgba)}, nil
+}
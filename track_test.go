@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+)
+
+func TestDrawTrack(t *testing.T) {
+	// a single tile of zoom level 0, the line runs horizontally through the center
+	grid := &TileGrid{Z: 0, Columns: 1, Rows: 1, TileSize: 256, Section: image.Rect(0, 0, 256, 256)}
+	line := orb.LineString{{-90, 0}, {0, 0}, {90, 0}}
+	white := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	red := color.NRGBA{0xff, 0, 0, 0xff}
+	style := LineStyle{Width: 4, Join: gg.LineJoinRound, Cap: gg.LineCapRound, Color: TrackColor, HaloWidth: 3, HaloColor: red}
+	dc := gg.NewContext(256, 256)
+	dc.SetColor(white)
+	dc.Clear()
+	DrawTrack(dc, grid, line, style, 1)
+
+	cases := []struct {
+		name     string
+		x, y     int
+		expected color.Color
+	}{
+		{"line", 128, 128, TrackColor},
+		// between two fixes the line is connected
+		{"segment", 100, 128, TrackColor},
+		{"halo", 128, 132, red},
+		{"background", 128, 140, white},
+		{"end", 250, 128, white},
+	}
+	for _, c := range cases {
+		if current := color.NRGBAModel.Convert(dc.Image().At(c.x, c.y)); current != color.NRGBAModel.Convert(c.expected) {
+			t.Errorf("%s: color is %v, expected %v", c.name, current, c.expected)
+		}
+	}
+}
+
+func TestParseHexColor(t *testing.T) {
+	if c, err := ParseHexColor("#2d55a6"); err != nil || c != color.Color(color.NRGBA{0x2d, 0x55, 0xa6, 0xff}) {
+		t.Errorf("Color is not parsed: %v %v", c, err)
+	}
+	if c, err := ParseHexColor("2d55a680"); err != nil || c != color.Color(color.NRGBA{0x2d, 0x55, 0xa6, 0x80}) {
+		t.Errorf("Color with alpha is not parsed: %v %v", c, err)
+	}
+	if _, err := ParseHexColor("#2d55"); err == nil {
+		t.Errorf("Invalid color does not return an error")
+	}
+}
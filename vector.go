@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"golang.org/x/image/draw"
+)
+
+// Types of the layers of a vector style
+const (
+	VectorFill  string = "fill"
+	VectorLine  string = "line"
+	VectorLabel string = "label"
+)
+
+// DefaultVectorTileSize is the size in pixels a vector tile is rasterized to
+const DefaultVectorTileSize int = 512
+
+// TileDecoder is implemented by tile sources whose tiles are not images, the cache
+// allows to composite the tile with tiles of other sources
+type TileDecoder interface {
+	DecodeTile(cache *TileCache, content []byte, z int16, x int16, y int16) (image.Image, error)
+}
+
+// VectorLayer draws the features of one layer of the vector tiles
+type VectorLayer struct {
+	// Layer is the name of the layer in the vector tiles, e.g. water
+	Layer string `json:"layer"`
+	// Type is one of fill, line or label
+	Type  string  `json:"type"`
+	Color string  `json:"color"`
+	Width float64 `json:"width"`
+	// Field is the property with the text of the labels (default name)
+	Field string `json:"field"`
+	// Filter restricts the features to the given values of their properties, e.g. {"class": ["motorway"]}
+	Filter  map[string][]string `json:"filter"`
+	MinZoom int16               `json:"min_zoom"`
+	MaxZoom int16               `json:"max_zoom"`
+
+	color color.Color
+}
+
+// VectorStyle defines which layers of the vector tiles are drawn and how,
+// the layers are drawn in the given order
+type VectorStyle struct {
+	Background string        `json:"background"`
+	Layers     []VectorLayer `json:"layers"`
+
+	background color.Color
+}
+
+// DefaultVectorStyle draws water, forests, terrain contours, roads and place labels of
+// tiles following the OpenMapTiles schema
+var DefaultVectorStyle = mustParseVectorStyle(&VectorStyle{
+	Background: "#f2efe9",
+	Layers: []VectorLayer{
+		{Layer: "landcover", Type: VectorFill, Color: "#c8dcb4", Filter: map[string][]string{"class": {"wood", "forest"}}},
+		{Layer: "water", Type: VectorFill, Color: "#aad3df"},
+		{Layer: "waterway", Type: VectorLine, Color: "#aad3df", Width: 1},
+		{Layer: "contour", Type: VectorLine, Color: "#b4967880", Width: 0.6},
+		{Layer: "boundary", Type: VectorLine, Color: "#9e9cab", Width: 1, Filter: map[string][]string{"admin_level": {"2"}}},
+		{Layer: "transportation", Type: VectorLine, Color: "#ffffff", Width: 1, MinZoom: 12, Filter: map[string][]string{"class": {"tertiary", "minor"}}},
+		{Layer: "transportation", Type: VectorLine, Color: "#fcd6a4", Width: 1.5, MinZoom: 8, Filter: map[string][]string{"class": {"primary", "secondary"}}},
+		{Layer: "transportation", Type: VectorLine, Color: "#e892a2", Width: 2, Filter: map[string][]string{"class": {"motorway", "trunk"}}},
+		{Layer: "aerodrome_label", Type: VectorLabel, Color: "#6a1b9a", MinZoom: 9},
+		{Layer: "place", Type: VectorLabel, Color: "#333333", Filter: map[string][]string{"class": {"city", "town"}}},
+		{Layer: "place", Type: VectorLabel, Color: "#555555", MinZoom: 11, Filter: map[string][]string{"class": {"village"}}},
+	},
+})
+
+// mustParseVectorStyle parses the built-in style
+func mustParseVectorStyle(style *VectorStyle) *VectorStyle {
+	if err := style.parse(); err != nil {
+		panic(err)
+	}
+	return style
+}
+
+// LoadVectorStyle reads the style from a json file
+func LoadVectorStyle(FileName string) (*VectorStyle, error) {
+	content, err := ioutil.ReadFile(FileName)
+	if err != nil {
+		return nil, err
+	}
+	var style VectorStyle
+	if err := json.Unmarshal(content, &style); err != nil {
+		return nil, fmt.Errorf("invalid vector style %s: %w", FileName, err)
+	}
+	if err := style.parse(); err != nil {
+		return nil, fmt.Errorf("invalid vector style %s: %w", FileName, err)
+	}
+	return &style, nil
+}
+
+// parse validates the style and parses its colors
+func (s *VectorStyle) parse() (err error) {
+	if s.Background != "" {
+		if s.background, err = ParseHexColor(s.Background); err != nil {
+			return
+		}
+	}
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		switch layer.Type {
+		case VectorFill, VectorLine, VectorLabel:
+		default:
+			return fmt.Errorf("unknown type %q of layer %s, available: fill, line, label", layer.Type, layer.Layer)
+		}
+		if layer.color, err = ParseHexColor(layer.Color); err != nil {
+			return
+		}
+		if layer.Width <= 0 {
+			layer.Width = 1
+		}
+		if layer.Field == "" {
+			layer.Field = "name"
+		}
+	}
+	return nil
+}
+
+// match returns whether the feature with the properties is drawn by the layer
+func (l *VectorLayer) match(properties map[string]interface{}) bool {
+	for key, values := range l.Filter {
+		value, ok := properties[key]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, v := range values {
+			if fmt.Sprint(value) == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// VectorSource rasterizes Mapbox Vector Tiles with a style, the tiles are either
+// drawn onto the background of the style or onto the tiles of a raster source
+type VectorSource struct {
+	TileSource
+	Style *VectorStyle
+	// Base is the raster source beneath the vector layers, nil uses the background
+	Base TileSource
+}
+
+// MaxZoom is limited by the base, so that both sources provide the tiles
+func (s *VectorSource) MaxZoom() int16 {
+	if s.Base != nil && s.Base.MaxZoom() < s.TileSource.MaxZoom() {
+		return s.Base.MaxZoom()
+	}
+	return s.TileSource.MaxZoom()
+}
+
+func (s *VectorSource) Attribution() string {
+	if s.Base == nil || s.Base.Attribution() == "" {
+		return s.TileSource.Attribution()
+	}
+	return s.Base.Attribution() + ", " + s.TileSource.Attribution()
+}
+
+// DecodeTile decodes the optionally gzipped vector tile and rasterizes it
+func (s *VectorSource) DecodeTile(cache *TileCache, content []byte, z int16, x int16, y int16) (image.Image, error) {
+	var (
+		layers mvt.Layers
+		err    error
+	)
+	if len(content) > 1 && content[0] == 0x1f && content[1] == 0x8b {
+		layers, err = mvt.UnmarshalGzipped(content)
+	} else {
+		layers, err = mvt.Unmarshal(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var base image.Image
+	if s.Base != nil {
+		im, tileErr := fetchTile(cache, s.Base, z, x, y)
+		if tileErr != nil {
+			return nil, tileErr
+		}
+		base = im
+	}
+	return s.Rasterize(layers, z, base), nil
+}
+
+// Rasterize draws the layers of the style onto the base image, which may be nil
+func (s *VectorSource) Rasterize(layers mvt.Layers, z int16, base image.Image) image.Image {
+	size := s.TileSize()
+	dc := gg.NewContext(size, size)
+	if base != nil {
+		draw.CatmullRom.Scale(dc.Image().(*image.RGBA), image.Rect(0, 0, size, size), base, base.Bounds(), draw.Src, nil)
+	} else if s.Style.background != nil {
+		dc.SetColor(s.Style.background)
+		dc.Clear()
+	}
+
+	byName := make(map[string]*mvt.Layer, len(layers))
+	for _, layer := range layers {
+		byName[layer.Name] = layer
+	}
+	for i := range s.Style.Layers {
+		style := &s.Style.Layers[i]
+		layer, ok := byName[style.Layer]
+		if !ok || z < style.MinZoom || (style.MaxZoom > 0 && z > style.MaxZoom) {
+			continue
+		}
+		extent := layer.Extent
+		if extent == 0 {
+			extent = mvt.DefaultExtent
+		}
+		scale := float64(size) / float64(extent)
+		dc.SetColor(style.color)
+		dc.SetLineWidth(style.Width)
+		dc.SetLineJoin(gg.LineJoinRound)
+		dc.SetLineCap(gg.LineCapRound)
+		for _, feature := range layer.Features {
+			if !style.match(feature.Properties) {
+				continue
+			}
+			switch style.Type {
+			case VectorFill:
+				drawPolygons(dc, feature.Geometry, scale)
+			case VectorLine:
+				drawLines(dc, feature.Geometry, scale)
+			case VectorLabel:
+				if text, ok := feature.Properties[style.Field].(string); ok && text != "" {
+					drawPointLabels(dc, feature.Geometry, scale, text, style.color)
+				}
+			}
+		}
+	}
+	return dc.Image()
+}
+
+// drawPolygons fills the polygons of the geometry, holes are left out by the even-odd rule
+func drawPolygons(dc *gg.Context, geometry orb.Geometry, scale float64) {
+	var polygons orb.MultiPolygon
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		polygons = orb.MultiPolygon{g}
+	case orb.MultiPolygon:
+		polygons = g
+	default:
+		return
+	}
+	dc.SetFillRuleEvenOdd()
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			traceTilePath(dc, ring, scale)
+			dc.ClosePath()
+		}
+	}
+	dc.Fill()
+}
+
+// drawLines strokes the lines of the geometry, polygons are drawn as their outline
+func drawLines(dc *gg.Context, geometry orb.Geometry, scale float64) {
+	switch g := geometry.(type) {
+	case orb.LineString:
+		traceTilePath(dc, g, scale)
+	case orb.MultiLineString:
+		for _, line := range g {
+			traceTilePath(dc, line, scale)
+		}
+	case orb.Polygon:
+		for _, ring := range g {
+			traceTilePath(dc, ring, scale)
+		}
+	case orb.MultiPolygon:
+		for _, polygon := range g {
+			for _, ring := range polygon {
+				traceTilePath(dc, ring, scale)
+			}
+		}
+	default:
+		return
+	}
+	dc.Stroke()
+}
+
+// traceTilePath adds the points in tile coordinates as new sub path
+func traceTilePath(dc *gg.Context, points []orb.Point, scale float64) {
+	dc.NewSubPath()
+	for _, p := range points {
+		dc.LineTo(p[0]*scale, p[1]*scale)
+	}
+}
+
+// drawPointLabels centers the text on the points of the geometry. Labels are
+// rasterized per tile, so labels not fitting into the tile are left out instead
+// of being cut at the tile border.
+func drawPointLabels(dc *gg.Context, geometry orb.Geometry, scale float64, text string, c color.Color) {
+	var points []orb.Point
+	switch g := geometry.(type) {
+	case orb.Point:
+		points = []orb.Point{g}
+	case orb.MultiPoint:
+		points = g
+	default:
+		return
+	}
+	w, h := dc.MeasureString(text)
+	size := float64(dc.Width())
+	for _, p := range points {
+		x, y := p[0]*scale, p[1]*scale
+		if x-w/2 < 0 || x+w/2 > size || y-h/2 < 0 || y+h/2 > size {
+			continue
+		}
+		dc.SetRGB(1, 1, 1)
+		for dy := -1.0; dy <= 1; dy++ {
+			for dx := -1.0; dx <= 1; dx++ {
+				dc.DrawStringAnchored(text, x+dx, y+dy, 0.5, 0.5)
+			}
+		}
+		dc.SetColor(c)
+		dc.DrawStringAnchored(text, x, y, 0.5, 0.5)
+	}
+}
+
+// IsVectorFormat returns whether the tiles of the format are vector tiles
+func IsVectorFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "pbf", "mvt":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// NewTestVectorTile encodes a tile with a water polygon covering the left half and a
+// motorway crossing the right half, the coordinates are in the default extent of 4096
+func NewTestVectorTile(t *testing.T) []byte {
+	water := geojson.NewFeatureCollection()
+	water.Append(geojson.NewFeature(orb.Polygon{{{0, 0}, {2048, 0}, {2048, 4096}, {0, 4096}, {0, 0}}}))
+	roads := geojson.NewFeatureCollection()
+	road := geojson.NewFeature(orb.LineString{{3072, 0}, {3072, 4096}})
+	road.Properties["class"] = "motorway"
+	roads.Append(road)
+	path := geojson.NewFeature(orb.LineString{{2560, 0}, {2560, 4096}})
+	path.Properties["class"] = "path"
+	roads.Append(path)
+	content, err := mvt.MarshalGzipped(mvt.NewLayers(map[string]*geojson.FeatureCollection{
+		"water":          water,
+		"transportation": roads,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestVectorSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "5", "8"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "5", "8", "5.pbf"), NewTestVectorTile(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := TileSourceConfig{Name: "vector", URL: filepath.Join(dir, "{z}", "{x}", "{y}.pbf"), Format: "pbf", MaxZoom: 14}
+	source, err := config.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.TileSize() != DefaultVectorTileSize {
+		t.Errorf("Vector tiles are rasterized to %d pixels", source.TileSize())
+	}
+	// local tiles are read without cache
+	cache := NewTileCache("", time.Hour, 0)
+	im, tileErr := fetchTile(cache, source, 5, 8, 5)
+	if tileErr != nil {
+		t.Fatal(tileErr)
+	}
+
+	expect := map[[2]int]color.NRGBA{
+		// water
+		{100, 256}: {0xaa, 0xd3, 0xdf, 0xff},
+		// motorway
+		{384, 256}: {0xe8, 0x92, 0xa2, 0xff},
+		// the path is not part of the default style
+		{320, 256}: {0xf2, 0xef, 0xe9, 0xff},
+		// background
+		{450, 256}: {0xf2, 0xef, 0xe9, 0xff},
+	}
+	for p, c := range expect {
+		if got := color.NRGBAModel.Convert(im.At(p[0], p[1])); got != c {
+			t.Errorf("Pixel %v is %v instead of %v", p, got, c)
+		}
+	}
+
+	if _, tileErr := fetchTile(cache, source, 5, 9, 5); tileErr == nil {
+		t.Errorf("Missing local tile does not fail")
+	}
+}
+
+func TestLoadVectorStyle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "style.json")
+	content := `{"layers": [{"layer": "roads", "type": "line", "color": "#000000", "filter": {"kind": ["highway"]}}]}`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	style, err := LoadVectorStyle(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := style.Layers[0]
+	if layer.Width != 1 || layer.Field != "name" {
+		t.Errorf("Defaults of the layer are not set: %+v", layer)
+	}
+	if !layer.match(map[string]interface{}{"kind": "highway"}) || layer.match(map[string]interface{}{"kind": "path"}) || layer.match(nil) {
+		t.Errorf("Filter of the layer does not match the properties")
+	}
+
+	if err := ioutil.WriteFile(file, []byte(`{"layers": [{"layer": "roads", "type": "polygon", "color": "#000000"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadVectorStyle(file); err == nil {
+		t.Errorf("Unknown layer type is accepted")
+	}
+}
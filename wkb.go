@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// Track is the flown line with the altitude (Z) and the time (M) of each fix,
+// Alt and Time are empty if the geometry does not contain them
+type Track struct {
+	Line orb.LineString
+	// Alt in meters
+	Alt []float64
+	// Time in seconds, e.g. since the epoch or the start of the flight
+	Time []float64
+}
+
+// flags of the extended wkb format of postgis
+const (
+	ewkbZ    uint32 = 0x80000000
+	ewkbM    uint32 = 0x40000000
+	ewkbSRID uint32 = 0x20000000
+)
+
+var ErrNotLineString = errors.New("wkb geometry is not a line string")
+
+// Scan decodes the line string from wkb (ST_AsBinary) or ewkb (ST_AsEWKB) including
+// the Z and M values that are discarded by orb, a null value results in an empty track
+func (t *Track) Scan(value interface{}) error {
+	*t = Track{}
+	if value == nil {
+		return nil
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("unsupported data type %T for wkb", value)
+	}
+	// the data might be hex encoded with \x prefix
+	if len(data) > 2 && data[0] == '\\' && data[1] == 'x' {
+		decoded := make([]byte, hex.DecodedLen(len(data)-2))
+		if _, err := hex.Decode(decoded, data[2:]); err != nil {
+			return err
+		}
+		data = decoded
+	}
+	return t.UnmarshalWKB(data)
+}
+
+// UnmarshalWKB decodes a 2D, Z, M or ZM line string
+func (t *Track) UnmarshalWKB(data []byte) error {
+	r := bytes.NewReader(data)
+	var order byte
+	if err := binary.Read(r, binary.LittleEndian, &order); err != nil {
+		return err
+	}
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if order == 0 {
+		byteOrder = binary.BigEndian
+	}
+	var geometryType uint32
+	if err := binary.Read(r, byteOrder, &geometryType); err != nil {
+		return err
+	}
+	hasZ, hasM := geometryType&ewkbZ != 0, geometryType&ewkbM != 0
+	if geometryType&ewkbSRID != 0 {
+		var srid uint32
+		if err := binary.Read(r, byteOrder, &srid); err != nil {
+			return err
+		}
+	}
+	// iso wkb encodes the dimensions as thousands of the type
+	geometryType &^= ewkbZ | ewkbM | ewkbSRID
+	switch geometryType / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	if geometryType%1000 != 2 {
+		return ErrNotLineString
+	}
+
+	var n uint32
+	if err := binary.Read(r, byteOrder, &n); err != nil {
+		return err
+	}
+	dimensions := 2
+	if hasZ {
+		dimensions++
+	}
+	if hasM {
+		dimensions++
+	}
+	if int(n)*dimensions*8 > r.Len() {
+		return fmt.Errorf("wkb line string with %d points is truncated", n)
+	}
+	coordinates := make([]float64, int(n)*dimensions)
+	if err := binary.Read(r, byteOrder, coordinates); err != nil {
+		return err
+	}
+
+	t.Line = make(orb.LineString, n)
+	if hasZ {
+		t.Alt = make([]float64, n)
+	}
+	if hasM {
+		t.Time = make([]float64, n)
+	}
+	for i := 0; i < int(n); i++ {
+		point := coordinates[i*dimensions : (i+1)*dimensions]
+		t.Line[i] = orb.Point{point[0], point[1]}
+		if hasZ {
+			t.Alt[i] = point[2]
+		}
+		if hasM {
+			t.Time[i] = point[dimensions-1]
+		}
+	}
+	// postgis encodes missing values as NaN
+	if len(t.Alt) > 0 && math.IsNaN(t.Alt[0]) {
+		t.Alt = nil
+	}
+	if len(t.Time) > 0 && math.IsNaN(t.Time[0]) {
+		t.Time = nil
+	}
+	return nil
+}
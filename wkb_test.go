@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// encodeWKB encodes a line string with the given geometry type and coordinates
+func encodeWKB(t *testing.T, order binary.ByteOrder, geometryType uint32, coordinates [][]float64) []byte {
+	buf := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	for _, value := range []interface{}{geometryType, uint32(len(coordinates))} {
+		if err := binary.Write(buf, order, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, point := range coordinates {
+		if err := binary.Write(buf, order, point); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestTrackScan(t *testing.T) {
+	cases := []struct {
+		name         string
+		order        binary.ByteOrder
+		geometryType uint32
+		coordinates  [][]float64
+		alt, time    bool
+	}{
+		{"2D", binary.LittleEndian, 2, [][]float64{{13.4, 52.5}, {13.5, 52.6}}, false, false},
+		{"ISO Z", binary.LittleEndian, 1002, [][]float64{{13.4, 52.5, 500}, {13.5, 52.6, 600}}, true, false},
+		{"ISO M", binary.BigEndian, 2002, [][]float64{{13.4, 52.5, 0}, {13.5, 52.6, 4}}, false, true},
+		{"ISO ZM", binary.LittleEndian, 3002, [][]float64{{13.4, 52.5, 500, 0}, {13.5, 52.6, 600, 4}}, true, true},
+		{"EWKB ZM", binary.BigEndian, 2 | ewkbZ | ewkbM, [][]float64{{13.4, 52.5, 500, 0}, {13.5, 52.6, 600, 4}}, true, true},
+	}
+	for _, c := range cases {
+		var track Track
+		if err := track.Scan(encodeWKB(t, c.order, c.geometryType, c.coordinates)); err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if len(track.Line) != 2 || track.Line[1][0] != 13.5 || track.Line[1][1] != 52.6 {
+			t.Errorf("%s: line is %v", c.name, track.Line)
+		}
+		if c.alt != (len(track.Alt) == 2) || (c.alt && track.Alt[1] != 600) {
+			t.Errorf("%s: altitude is %v", c.name, track.Alt)
+		}
+		if c.time != (len(track.Time) == 2) || (c.time && track.Time[1] != 4) {
+			t.Errorf("%s: time is %v", c.name, track.Time)
+		}
+	}
+}
+
+func TestTrackScanEWKBWithSRID(t *testing.T) {
+	// the srid 4326 follows the geometry type
+	data := encodeWKB(t, binary.LittleEndian, 2|ewkbZ|ewkbSRID, [][]float64{{1, 2, 3}})
+	data = append(data[:5], append([]byte{0xe6, 0x10, 0, 0}, data[5:]...)...)
+	var track Track
+	// hex encoded like the bytea output of postgres
+	if err := track.Scan([]byte(`\x` + hex.EncodeToString(data))); err != nil {
+		t.Fatal(err)
+	}
+	if len(track.Line) != 1 || track.Line[0][1] != 2 || len(track.Alt) != 1 || track.Alt[0] != 3 {
+		t.Errorf("Track is %+v", track)
+	}
+}
+
+func TestTrackScanInvalid(t *testing.T) {
+	var track Track
+	if err := track.Scan(nil); err != nil || len(track.Line) != 0 {
+		t.Errorf("Null should result in an empty track: %v", err)
+	}
+	if err := track.Scan(encodeWKB(t, binary.LittleEndian, 1, [][]float64{{1, 2}})); !errors.Is(err, ErrNotLineString) {
+		t.Errorf("Point should not be decoded: %v", err)
+	}
+	data := encodeWKB(t, binary.LittleEndian, 2, [][]float64{{1, 2}, {3, 4}})
+	if err := track.Scan(data[:len(data)-8]); err == nil {
+		t.Error("Truncated line string should not be decoded")
+	}
+}
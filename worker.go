@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	DefaultMaxAttempts  int           = 3
+	DefaultRetryDelay   time.Duration = 30 * time.Second
+	DefaultPollInterval time.Duration = 30 * time.Second
+	// MaxRetryDelay caps the exponential backoff of failed jobs
+	MaxRetryDelay time.Duration = 6 * time.Hour
+)
+
+// ImageStore saves the images rendered by the worker
+type ImageStore interface {
+	// Save stores the png and returns its location
+	Save(ctx context.Context, Name string, Content []byte) (string, error)
+}
+
+// DirectoryStore saves the images as files of the directory
+type DirectoryStore struct {
+	Dir string
+}
+
+func (s *DirectoryStore) Save(ctx context.Context, Name string, Content []byte) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0777); err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.Dir, Name)
+	return path, ioutil.WriteFile(path, Content, 0666)
+}
+
+// S3Store uploads the images to the bucket
+type S3Store struct {
+	Client *s3.S3
+	Bucket string
+}
+
+func (s *S3Store) Save(ctx context.Context, Name string, Content []byte) (string, error) {
+	_, err := s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(Name),
+		Body:        bytes.NewReader(Content),
+		ContentType: aws.String("image/png"),
+	})
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, Name), err
+}
+
+// Worker renders the jobs of the queue until the context is cancelled, failed jobs
+// are retried with an exponential backoff
+type Worker struct {
+	Queue   JobQueue
+	Source  FlightSource
+	Options RenderOptions
+	Store   ImageStore
+	Prefix  string
+	Workers int
+	// MaxAttempts of a job, missing flights and flights without geometry are not retried
+	MaxAttempts int
+	// RetryDelay is doubled with every attempt up to MaxRetryDelay
+	RetryDelay time.Duration
+	// PollInterval of idle workers, the queue is polled earlier on Notify
+	PollInterval time.Duration
+	// RenewInterval of the lease of a job being rendered, it has to be shorter than the
+	// lease so that slow renderings are not claimed by a second worker, 0 never renews
+	RenewInterval time.Duration
+	// Notify wakes an idle worker, e.g. on a notification of the db, nil only polls
+	Notify <-chan struct{}
+	// ExitWhenEmpty returns once no job is due instead of waiting for new jobs
+	ExitWhenEmpty bool
+}
+
+// Run starts the workers and returns when the context is cancelled, the jobs being
+// rendered are released for the next worker. With ExitWhenEmpty a failing claim is
+// returned, otherwise the claim is retried after the poll interval.
+func (w *Worker) Run(ctx context.Context) error {
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	log.Printf("Waiting for jobs with %d workers\n", workers)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		claimErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.work(ctx); err != nil {
+				mu.Lock()
+				if claimErr == nil {
+					claimErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claimErr != nil {
+		return fmt.Errorf("claiming a job failed: %w", claimErr)
+	}
+	return ctx.Err()
+}
+
+// work claims and renders jobs until the queue is empty, then waits for the poll
+// interval or a notification
+func (w *Worker) work(ctx context.Context) error {
+	for ctx.Err() == nil {
+		job, err := w.Queue.Claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			if w.ExitWhenEmpty {
+				return err
+			}
+			log.Printf("Claiming a job failed: %s\n", err)
+		}
+		if job != nil {
+			w.process(ctx, job)
+			continue
+		}
+		if w.ExitWhenEmpty {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+		case <-w.Notify:
+		case <-time.After(w.PollInterval):
+		}
+	}
+	return nil
+}
+
+// process renders the job and records the result in the queue
+func (w *Worker) process(ctx context.Context, job *Job) {
+	log.Printf("Job %d: rendering Flight ID %d, attempt %d\n", job.ID, job.FlightID, job.Attempts)
+	stop := w.renew(job)
+	body, degraded, err := encodeFlights(ctx, w.Source, []uint{job.FlightID}, w.Options)
+	var result string
+	if err == nil {
+		result, err = w.Store.Save(ctx, fmt.Sprintf("%sFlight_%d.png", w.Prefix, job.FlightID), body)
+	}
+	stop()
+	// the result is recorded even if the worker is shutting down
+	update, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	switch {
+	case err == nil:
+		log.Printf("Job %d: Flight ID %d saved to %s with %d degraded tiles\n", job.ID, job.FlightID, result, len(degraded))
+		err = w.Queue.Complete(update, job, result)
+	case ctx.Err() != nil:
+		log.Printf("Job %d: interrupted, releasing it\n", job.ID)
+		err = w.Queue.Release(update, job)
+	case errors.Is(err, ErrFlightNotFound) || errors.Is(err, ErrEmptyGeometry) || job.Attempts >= w.MaxAttempts:
+		log.Printf("Job %d: failed: %s\n", job.ID, err)
+		err = w.Queue.Fail(update, job, err)
+	default:
+		delay := w.retryDelay(job.Attempts)
+		log.Printf("Job %d: failed, retrying in %s: %s\n", job.ID, delay, err)
+		err = w.Queue.Retry(update, job, err, delay)
+	}
+	if err != nil {
+		// the job is claimed again after its lease
+		log.Printf("Job %d: updating the queue failed: %s\n", job.ID, err)
+	}
+}
+
+// renew extends the lease of the job every RenewInterval until the returned function
+// is called, which waits for a running renewal
+func (w *Worker) renew(job *Job) func() {
+	if w.RenewInterval <= 0 {
+		return func() {}
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(w.RenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+			if err := w.Queue.Renew(ctx, job); err != nil {
+				log.Printf("Job %d: renewing the lease failed: %s\n", job.ID, err)
+			}
+			cancel()
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// retryDelay doubles the delay for every attempt after the first one, the shift is
+// capped so that many attempts do not overflow the duration
+func (w *Worker) retryDelay(Attempts int) time.Duration {
+	delay := w.RetryDelay
+	for i := 1; i < Attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		return MaxRetryDelay
+	}
+	return delay
+}
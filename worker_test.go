@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MemoryJob is a job of the MemoryQueue with its state
+type MemoryJob struct {
+	Job
+	Status   string
+	Result   string
+	Error    string
+	Renewals int
+}
+
+// MemoryQueue hands out the jobs in the order of their ids, delays are ignored
+type MemoryQueue struct {
+	mu   sync.Mutex
+	Jobs map[int64]*MemoryJob
+}
+
+func NewMemoryQueue(FlightIDs ...uint) *MemoryQueue {
+	q := &MemoryQueue{Jobs: make(map[int64]*MemoryJob)}
+	for i, id := range FlightIDs {
+		q.Jobs[int64(i+1)] = &MemoryJob{Job: Job{ID: int64(i + 1), FlightID: id}, Status: JobPending}
+	}
+	return q
+}
+
+func (q *MemoryQueue) Claim(ctx context.Context) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ids := make([]int64, 0, len(q.Jobs))
+	for id := range q.Jobs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		if job := q.Jobs[id]; job.Status == JobPending {
+			job.Status = JobRunning
+			job.Attempts++
+			claimed := job.Job
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (q *MemoryQueue) set(job *Job, Status string, Result string, Reason error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stored := q.Jobs[job.ID]
+	stored.Status, stored.Result = Status, Result
+	if Status == JobDone {
+		stored.Error = ""
+	} else if Reason != nil {
+		stored.Error = Reason.Error()
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Complete(ctx context.Context, job *Job, Result string) error {
+	return q.set(job, JobDone, Result, nil)
+}
+
+func (q *MemoryQueue) Retry(ctx context.Context, job *Job, Reason error, Delay time.Duration) error {
+	return q.set(job, JobPending, "", Reason)
+}
+
+func (q *MemoryQueue) Fail(ctx context.Context, job *Job, Reason error) error {
+	return q.set(job, JobFailed, "", Reason)
+}
+
+func (q *MemoryQueue) Release(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	q.Jobs[job.ID].Attempts--
+	q.mu.Unlock()
+	return q.set(job, JobPending, "", nil)
+}
+
+func (q *MemoryQueue) Renew(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Jobs[job.ID].Renewals++
+	return nil
+}
+
+// FailingQueue fails to claim jobs like an unreachable db
+type FailingQueue struct {
+	*MemoryQueue
+}
+
+func (q *FailingQueue) Claim(ctx context.Context) (*Job, error) {
+	return nil, errors.New("dial tcp: connection refused")
+}
+
+// FlakySource fails the flights for the given number of times
+type FlakySource struct {
+	FlightSource
+	mu       sync.Mutex
+	Failures map[uint]int
+}
+
+// DelayedSource renders the flights slower than the lease of the test
+type DelayedSource struct {
+	FlightSource
+	Delay time.Duration
+}
+
+func (s *DelayedSource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	time.Sleep(s.Delay)
+	return s.FlightSource.Flight(ctx, FlightID)
+}
+
+func (s *FlakySource) Flight(ctx context.Context, FlightID uint) (*Flight, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Failures[FlightID] > 0 {
+		s.Failures[FlightID]--
+		return nil, errors.New("connection reset")
+	}
+	return s.FlightSource.Flight(ctx, FlightID)
+}
+
+func TestWorker(t *testing.T) {
+	server := NewSolidTileServer(t, color.White)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "casper")
+	CheckError(t, err)
+	defer os.RemoveAll(dir)
+
+	// flight 3 is empty and flight 4 does not exist, both are not retried
+	queue := NewMemoryQueue(1, 2, 3, 4)
+	worker := &Worker{
+		Queue:         queue,
+		Source:        &FlakySource{FlightSource: NewTestSource(), Failures: map[uint]int{1: 1, 2: 5}},
+		Options:       NewTestOptions(server),
+		Store:         &DirectoryStore{Dir: dir},
+		Prefix:        "thumb_",
+		Workers:       2,
+		MaxAttempts:   3,
+		ExitWhenEmpty: true,
+	}
+	CheckError(t, worker.Run(context.Background()))
+
+	for id, expected := range map[int64]MemoryJob{
+		1: {Job: Job{Attempts: 2}, Status: JobDone, Result: filepath.Join(dir, "thumb_Flight_1.png")},
+		2: {Job: Job{Attempts: 3}, Status: JobFailed, Error: "connection reset"},
+		3: {Job: Job{Attempts: 1}, Status: JobFailed, Error: "flight has no geometry: 3"},
+		4: {Job: Job{Attempts: 1}, Status: JobFailed, Error: "flight not found: 4"},
+	} {
+		job := queue.Jobs[id]
+		if job.Status != expected.Status || job.Attempts != expected.Attempts || job.Result != expected.Result || job.Error != expected.Error {
+			t.Errorf("Job %d is %+v instead of %+v", id, *job, expected)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "thumb_Flight_1.png")); err != nil {
+		t.Errorf("Image of the job is missing: %s", err)
+	}
+
+	// the lease of a slow rendering is renewed until the job is done
+	queue = NewMemoryQueue(1)
+	worker.Queue, worker.Source, worker.RenewInterval = queue, &DelayedSource{FlightSource: NewTestSource(), Delay: 50 * time.Millisecond}, 10*time.Millisecond
+	CheckError(t, worker.Run(context.Background()))
+	if job := queue.Jobs[1]; job.Status != JobDone || job.Renewals == 0 {
+		t.Errorf("Lease of the slow job %+v is not renewed", *job)
+	}
+
+	// a failing claim is reported when the worker exits on an empty queue
+	worker.Queue = &FailingQueue{MemoryQueue: NewMemoryQueue(1)}
+	if err := worker.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Failing claim is not reported: %v", err)
+	}
+
+	// a cancelled worker does not claim jobs
+	queue = NewMemoryQueue(1)
+	worker.Queue, worker.ExitWhenEmpty = queue, false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := worker.Run(ctx); !errors.Is(err, context.Canceled) || queue.Jobs[1].Status != JobPending {
+		t.Errorf("Cancelled worker returns %v with job %+v", err, *queue.Jobs[1])
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	worker := &Worker{RetryDelay: DefaultRetryDelay}
+	for attempts, expected := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 4: 4 * time.Minute, 100: MaxRetryDelay} {
+		if delay := worker.retryDelay(attempts); delay != expected {
+			t.Errorf("Delay of attempt %d is %s, expected %s", attempts, delay, expected)
+		}
+	}
+}